@@ -1,75 +1,98 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
-	"net/http"
-	"net/http/cookiejar"
+	"log/slog"
+	"os"
 	"sync"
 	"time"
 
-	"reniec/codigo"
-
-	_ "github.com/lib/pq"
+	"personas/core"
 )
 
+// NumWorkers es el valor por defecto de -workers. El ritmo lo pone el
+// limitador compartido (ver -limite), así que por defecto no hay pausa extra.
 const NumWorkers = 2
 
 type Resultado struct {
-	DNI   string
-	Datos *codigo.DatosPersona
-	Error error
+	WorkerID int
+	DNI      string
+	Datos    *core.Persona
+	Intentos int
+	Duracion time.Duration
+	Error    error
 }
 
 // Estructura para mantener el estado del worker
 type WorkerState struct {
-	ID           int
-	Client       *http.Client
-	Token        string
-	ConsultCount int
-	TargetURL    string
+	ID     int
+	Fuente *core.FuenteEldniDatos // sesión y token propios del worker
 }
 
 func main() {
-	dbConfig := codigo.DBConfig{
-		Host:     "localhost",
-		Port:     5433,
-		User:     "postgres",
-		Password: "admin123",
-		DBName:   "personas",
+	base := core.ConfigDefecto()
+	base.Workers = NumWorkers
+	base.Pausa = 0
+
+	cfg, _, err := core.CargarConfig("complete_name", os.Args[1:], base)
+	if err != nil {
+		log.Fatalf("Error en la configuración: %v", err)
+	}
+	if err := core.ConfigurarLogs(cfg.Log); err != nil {
+		log.Fatalf("Error en la configuración: %v", err)
 	}
 
-	db, err := codigo.ConectarDB(dbConfig)
+	drenar, abortar, stop := core.ContextoApagado(context.Background())
+	defer stop()
+
+	if cfg.Metricas != "" {
+		core.ServirMetricas(abortar, cfg.Metricas)
+	}
+
+	db, err := core.ConectarDB(cfg.DB)
 	if err != nil {
 		log.Fatalf("Error conectando a la base de datos: %v", err)
 	}
 	defer db.Close()
 
-	fmt.Printf("🚀 Iniciando procesamiento con %d workers con delays escalonados...\n", NumWorkers)
+	if err := core.ComprobarEsquema(abortar, db); err != nil {
+		log.Fatalf("Error en el esquema de la base de datos: %v", err)
+	}
+
+	slog.Info("iniciando procesamiento", "workers", cfg.Workers)
 	startTime := time.Now()
 
-	err = procesarDatosIncompletos(db)
+	err = procesarDatosIncompletos(drenar, abortar, db, cfg)
 	if err != nil {
 		log.Fatalf("Error procesando datos: %v", err)
 	}
 
+	if drenar.Err() != nil {
+		fmt.Printf("⚠️  Proceso interrumpido tras %v\n", time.Since(startTime))
+		return
+	}
 	fmt.Printf("🎉 Proceso completado en %v\n", time.Since(startTime))
 }
 
-func procesarDatosIncompletos(db *sql.DB) error {
-	dnis, err := codigo.ObtenerDNIsIncompletos(db)
+// procesarDatosIncompletos deja de repartir DNIs cuando se cancela drenar;
+// las consultas en curso y la escritura de sus resultados usan abortar
+func procesarDatosIncompletos(drenar, abortar context.Context, db *sql.DB, cfg core.Config) error {
+	dnis, err := core.ObtenerDNIsIncompletos(abortar, db, core.NombreEldniDatos)
 	if err != nil {
 		return err
 	}
 
 	total := len(dnis)
 	if total == 0 {
-		fmt.Println("✨ No hay DNIs con datos incompletos")
+		slog.Info("no hay DNIs con datos incompletos")
 		return nil
 	}
 
-	fmt.Printf("📋 Procesando %d DNIs con datos incompletos\n", total)
+	slog.Info("procesando DNIs con datos incompletos", "total", total)
+	progreso := core.NuevoProgreso(total, cfg.Workers, core.RitmoLimite(cfg.Limites, core.NombreEldniDatos))
 
 	dniChan := make(chan string, total)
 	resultadoChan := make(chan Resultado, total)
@@ -83,37 +106,49 @@ func procesarDatosIncompletos(db *sql.DB) error {
 	}
 	close(dniChan)
 
-	// Iniciar workers con tokens individuales
-	for i := 0; i < NumWorkers; i++ {
+	// Iniciar workers con tokens individuales y un limitador común
+	limitador := core.NuevosLimitadores(cfg.Limites).Para(core.NombreEldniDatos, cfg.URLs.EldniDatos)
+	for i := 0; i < cfg.Workers; i++ {
 		wg.Add(1)
-		go workerConToken(i+1, dniChan, resultadoChan, &wg)
+		go workerConToken(drenar, progreso.ConWorker(abortar, i+1), i+1, cfg, limitador, progreso, dniChan, resultadoChan, &wg)
 	}
 
-	// Procesar resultados en goroutine separada
+	// Procesar resultados en goroutine separada; se guardan por lotes y cada
+	// uno se cuenta cuando su lote queda escrito
+	escritor := core.NuevoEscritorLotes(abortar, db, cfg.Lotes, cfg.Reconsulta)
 	var processingWg sync.WaitGroup
 	processingWg.Add(1)
 	go func() {
 		defer processingWg.Done()
 		for resultado := range resultadoChan {
-			mu.Lock()
-			if resultado.Error != nil {
-				errores++
-				fmt.Printf("❌ Error DNI %s: %v\n", resultado.DNI, resultado.Error)
-			} else {
-				err := codigo.ActualizarDatosPersona(db, resultado.Datos)
-				if err != nil {
+			escritura := core.Escritura{Persona: core.Persona{DNI: resultado.DNI}, Fuente: core.NombreEldniDatos, Error: resultado.Error}
+			if resultado.Datos != nil {
+				escritura.Persona = *resultado.Datos
+			}
+			escritura.Listo = func(_ bool, errBD error) {
+				mu.Lock()
+				defer mu.Unlock()
+				attrs := append([]any{"worker_id", resultado.WorkerID},
+					core.AtributosConsulta(resultado.DNI, core.NombreEldniDatos, resultado.Intentos, resultado.Duracion, resultado.Error)...)
+				err := resultado.Error
+				switch {
+				case err != nil:
 					errores++
-					fmt.Printf("❌ Error BD DNI %s: %v\n", resultado.DNI, err)
-				} else {
+					slog.Warn("consulta fallida", append(attrs, "error", err)...)
+					if errBD != nil {
+						slog.Error("error registrando resultado", append(attrs, "error", errBD)...)
+					}
+				case errBD != nil:
+					err = errBD
+					errores++
+					slog.Error("error guardando datos", append(attrs, "error", err)...)
+				default:
 					exitosos++
-					fmt.Printf("✅ Worker DNI %s: %s %s %s\n",
-						resultado.DNI,
-						resultado.Datos.Nombres,
-						resultado.Datos.ApellidoPaterno,
-						resultado.Datos.ApellidoMaterno)
+					slog.Info("datos obtenidos", attrs...)
 				}
+				progreso.Avanzar(err)
 			}
-			mu.Unlock()
+			escritor.Agregar(escritura)
 		}
 	}()
 
@@ -121,155 +156,56 @@ func procesarDatosIncompletos(db *sql.DB) error {
 	wg.Wait()
 	close(resultadoChan)
 
-	// Esperar a que termine el procesamiento de resultados
+	// Esperar a que termine el procesamiento de resultados y se escriba el
+	// último lote
 	processingWg.Wait()
+	escritor.Cerrar()
+	progreso.Terminar()
 
-	fmt.Printf("\n📊 Resultados finales: %d exitosos, %d errores de %d total\n", exitosos, errores, total)
+	fmt.Printf("\n📊 Resultados finales: %d exitosos, %d errores, %d sin procesar de %d total\n",
+		exitosos, errores, total-exitosos-errores, total)
 	return nil
 }
 
-func workerConToken(workerID int, dniChan <-chan string, resultadoChan chan<- Resultado, wg *sync.WaitGroup) {
+// abortar lleva el worker de progreso para que las consultas informen su estado
+func workerConToken(drenar, abortar context.Context, workerID int, cfg core.Config, limitador *core.Limitador, progreso *core.Progreso, dniChan <-chan string, resultadoChan chan<- Resultado, wg *sync.WaitGroup) {
 	defer wg.Done()
+	defer progreso.WorkerTerminado(workerID)
 
-	// Crear estado único para este worker
+	// Crear estado único para este worker; la fuente renueva el token
+	// cada core.ConsultasPorToken consultas
 	state := &WorkerState{
-		ID:           workerID,
-		ConsultCount: 0,
-		TargetURL:    "https://eldni.com/pe/buscar-datos-por-dni",
-	}
-
-	// Crear cliente HTTP único con jar de cookies propio
-	jar, _ := cookiejar.New(nil)
-	state.Client = &http.Client{
-		Timeout: 30 * time.Second,
-		Jar:     jar,
+		ID:     workerID,
+		Fuente: core.NuevaFuenteEldniDatos(cfg.OpcionesRed(limitador)...),
 	}
 
 	// Obtener token inicial
-	fmt.Printf("🔑 Worker %d obteniendo token inicial...\n", workerID)
-	if err := renovarToken(state); err != nil {
-		fmt.Printf("❌ Worker %d falló al obtener token inicial: %v\n", workerID, err)
+	slog.Debug("obteniendo token inicial", "worker_id", workerID)
+	if err := renovarToken(abortar, state); err != nil {
+		slog.Error("no se pudo obtener el token inicial", "worker_id", workerID, "error", err)
 		return
 	}
 
 	for dni := range dniChan {
-		// Renovar token cada 3 consultas
-		if state.ConsultCount%3 == 0 && state.ConsultCount > 0 {
-			fmt.Printf("🔄 Worker %d renovando token (consulta #%d)...\n", workerID, state.ConsultCount)
-			if err := renovarToken(state); err != nil {
-				fmt.Printf("⚠️ Worker %d falló al renovar token: %v\n", workerID, err)
-				// Continuar con el token actual si falla la renovación
-			}
+		if drenar.Err() != nil {
+			break
 		}
 
-		datos, err := procesarDNIConToken(dni, state)
-		resultadoChan <- Resultado{DNI: dni, Datos: datos, Error: err}
-
-		state.ConsultCount++
+		inicio := time.Now()
+		datos, intentos, err := core.LookupConReintentos(abortar, state.Fuente, dni, cfg.Reintentos)
+		resultadoChan <- Resultado{WorkerID: workerID, DNI: dni, Datos: datos, Intentos: intentos, Duracion: time.Since(inicio), Error: err}
 
-		// Rate limiting más agresivo para evitar bloqueo IP
-		sleepTime := time.Duration(5+workerID*2) * time.Second
-		time.Sleep(sleepTime)
+		core.Dormir(drenar, cfg.Pausa)
 	}
 
-	fmt.Printf("✅ Worker %d completado - %d consultas realizadas\n", workerID, state.ConsultCount)
+	slog.Info("worker terminado", "worker_id", workerID, "consultas", state.Fuente.Consultas())
 }
 
-func renovarToken(state *WorkerState) error {
-	req, err := http.NewRequest("GET", state.TargetURL, nil)
-	if err != nil {
-		return err
-	}
-	codigo.SetHeaders(req)
-
-	resp, err := state.Client.Do(req)
-	if err != nil {
+func renovarToken(ctx context.Context, state *WorkerState) error {
+	if err := state.Fuente.RenovarToken(ctx); err != nil {
 		return err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode >= 400 {
-		return fmt.Errorf("error del servidor: %d", resp.StatusCode)
-	}
-
-	body, err := codigo.LeerRespuesta(resp)
-	if err != nil {
-		return err
-	}
-
-	token, err := codigo.ExtraerToken(string(body))
-	if err != nil {
-		return err
-	}
-
-	state.Token = token
-	fmt.Printf("🔑 Worker %d token renovado exitosamente\n", state.ID)
+	slog.Debug("token renovado", "worker_id", state.ID, "source", core.NombreEldniDatos)
 	return nil
 }
-
-func procesarDNIConToken(dni string, state *WorkerState) (*codigo.DatosPersona, error) {
-	maxReintentos := 2 // Reducir reintentos
-	var lastError error
-
-	for intento := 1; intento <= maxReintentos; intento++ {
-		datos, err := consultarDNIConToken(dni, state)
-		if err == nil {
-			return datos, nil
-		}
-
-		lastError = err
-
-		// Si es error 429, esperar más tiempo antes del siguiente intento
-		if isRateLimitError(err) {
-			waitTime := time.Duration(30+intento*30) * time.Second
-			fmt.Printf("⏳ Worker %d esperando %v por rate limit...\n", state.ID, waitTime)
-			time.Sleep(waitTime)
-
-			// Renovar token después de esperar
-			if renewErr := renovarToken(state); renewErr != nil {
-				fmt.Printf("⚠️ Worker %d falló al renovar token: %v\n", state.ID, renewErr)
-			}
-		}
-
-		fmt.Printf("⚠️ Worker %d - Reintento %d/%d para DNI %s: %v\n", state.ID, intento, maxReintentos, dni, err)
-
-		if intento < maxReintentos {
-			// Backoff exponencial más largo: 10s, 20s
-			backoff := time.Duration(intento*10) * time.Second
-			time.Sleep(backoff)
-		}
-	}
-
-	return nil, fmt.Errorf("worker %d agotó %d reintentos: %v", state.ID, maxReintentos, lastError)
-}
-
-func consultarDNIConToken(dni string, state *WorkerState) (*codigo.DatosPersona, error) {
-	if state.Token == "" {
-		return nil, fmt.Errorf("token no disponible")
-	}
-
-	return codigo.EnviarFormularioConToken(state.Client, state.TargetURL, dni, state.Token)
-}
-
-func isRateLimitError(err error) bool {
-	if err == nil {
-		return false
-	}
-	return contains(err.Error(), "429")
-}
-
-func contains(s, substr string) bool {
-	return len(s) >= len(substr) &&
-		(s == substr || len(s) > len(substr) &&
-			(s[:len(substr)] == substr || s[len(s)-len(substr):] == substr ||
-				indexSubstring(s, substr) != -1))
-}
-
-func indexSubstring(s, substr string) int {
-	for i := 0; i <= len(s)-len(substr); i++ {
-		if s[i:i+len(substr)] == substr {
-			return i
-		}
-	}
-	return -1
-}
@@ -0,0 +1,65 @@
+package core
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// ContextoApagado escucha SIGINT/SIGTERM y devuelve dos contextos:
+//   - drenar se cancela con la primera señal: los workers dejan de tomar
+//     DNIs nuevos pero terminan los que están en curso y se guardan los
+//     resultados pendientes.
+//   - abortar se cancela con la segunda señal: se cortan también las
+//     consultas y escrituras en vuelo.
+//
+// abortar cancela también drenar. stop libera el manejo de señales.
+func ContextoApagado(parent context.Context) (drenar, abortar context.Context, stop func()) {
+	abortar, cancelAbortar := context.WithCancel(parent)
+	drenar, cancelDrenar := context.WithCancel(abortar)
+
+	senales := make(chan os.Signal, 2)
+	signal.Notify(senales, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case <-senales:
+			slog.Warn("señal recibida: terminando los DNIs en curso (otra vez para abortar)")
+			cancelDrenar()
+		case <-abortar.Done():
+			return
+		}
+		select {
+		case <-senales:
+			slog.Warn("abortando consultas en curso")
+			cancelAbortar()
+		case <-abortar.Done():
+		}
+	}()
+
+	stop = func() {
+		signal.Stop(senales)
+		cancelAbortar()
+	}
+	return drenar, abortar, stop
+}
+
+// Dormir espera d o hasta que ctx se cancele, en cuyo caso devuelve ctx.Err()
+func Dormir(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
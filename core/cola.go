@@ -0,0 +1,314 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Estados de un job en enrichment_jobs
+const (
+	EstadoPendiente = "pending"
+	EstadoEnCurso   = "running"
+	EstadoHecho     = "done"
+	EstadoFallido   = "failed"
+)
+
+// PoliticaCola decide cuántas veces se reintenta un job y cuánto se espera
+// entre intentos. Agotados los intentos el job queda en failed y no se vuelve
+// a encolar esa fuente para ese DNI hasta que venza su plazo de Reconsulta.
+type PoliticaCola struct {
+	MaxIntentos int
+	EsperaBase  time.Duration
+	EsperaMax   time.Duration
+	Reconsulta  Reconsulta
+}
+
+func PoliticaColaDefecto() PoliticaCola {
+	return PoliticaCola{
+		MaxIntentos: 5,
+		EsperaBase:  time.Minute,
+		EsperaMax:   6 * time.Hour,
+		Reconsulta:  ReconsultaDefecto(),
+	}
+}
+
+// Espera devuelve el tiempo hasta el siguiente intento: se duplica con cada
+// intento fallido hasta EsperaMax
+func (p PoliticaCola) Espera(intentos int) time.Duration {
+	espera := p.EsperaBase
+	for i := 1; i < intentos && espera < p.EsperaMax; i++ {
+		espera *= 2
+	}
+	if espera > p.EsperaMax {
+		espera = p.EsperaMax
+	}
+	return espera
+}
+
+// Trabajo agrupa los jobs de un DNI reclamados juntos, uno por fuente
+type Trabajo struct {
+	DNI        string
+	Fuentes    []string
+	Intentos   map[string]int // intentos contando el actual
+	Trabajador string
+}
+
+// NombreTrabajador identifica a un worker entre procesos y máquinas en locked_by
+func NombreTrabajador(programa string, workerID int) string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "desconocido"
+	}
+	return fmt.Sprintf("%s@%s:%d/%d", programa, host, os.Getpid(), workerID)
+}
+
+// EncolarTrabajos crea los jobs que falten para las filas incompletas según
+// el plan de cada una. Es idempotente: los jobs existentes no se tocan y las
+// fuentes ya agotadas para un DNI (done o failed) se excluyen del plan, así
+// que su lugar lo toma la siguiente fuente que provea esos campos. Antes
+// vuelve a pending los jobs fallidos cuyo plazo de reconsulta ya venció.
+func EncolarTrabajos(ctx context.Context, db *sql.DB, r *Registro) (int, error) {
+	defer medirEscritura("encolar", time.Now())
+
+	_, err := db.ExecContext(ctx, `
+		UPDATE enrichment_jobs j
+		SET status = $1, attempts = 0, next_attempt_at = now(), updated_at = now()
+		FROM lookup_outcomes o
+		WHERE o.dni = j.dni AND o.source = j.source
+		  AND j.status = $2 AND o.recheck_after <= now()`,
+		EstadoPendiente, EstadoFallido)
+	if err != nil {
+		return 0, err
+	}
+
+	personas, err := ObtenerPersonasIncompletas(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+	agotadas, err := fuentesAgotadas(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO enrichment_jobs (dni, source)
+		VALUES ($1, $2)
+		ON CONFLICT (dni, source) DO NOTHING`)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	nuevos := 0
+	for _, p := range personas {
+		for _, f := range Planificar(r, p.Faltantes(), agotadas[p.DNI]...) {
+			res, err := stmt.ExecContext(ctx, p.DNI, f.Name())
+			if err != nil {
+				return 0, fmt.Errorf("DNI %s: %v", p.DNI, err)
+			}
+			if n, _ := res.RowsAffected(); n > 0 {
+				nuevos++
+			}
+		}
+	}
+
+	return nuevos, tx.Commit()
+}
+
+func fuentesAgotadas(ctx context.Context, db *sql.DB) (map[string][]string, error) {
+	rows, err := db.QueryContext(ctx,
+		"SELECT dni, source FROM enrichment_jobs WHERE status IN ($1, $2)",
+		EstadoHecho, EstadoFallido)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	agotadas := make(map[string][]string)
+	for rows.Next() {
+		var dni, fuente string
+		if err := rows.Scan(&dni, &fuente); err != nil {
+			return nil, err
+		}
+		agotadas[dni] = append(agotadas[dni], fuente)
+	}
+	return agotadas, rows.Err()
+}
+
+// RecuperarTrabajos devuelve a pending los jobs que quedaron en running más
+// de vencimiento, p. ej. porque el proceso que los tenía murió
+func RecuperarTrabajos(ctx context.Context, db *sql.DB, vencimiento time.Duration) (int64, error) {
+	res, err := db.ExecContext(ctx, `
+		UPDATE enrichment_jobs
+		SET status = $1, locked_by = NULL, locked_at = NULL, updated_at = now()
+		WHERE status = $2 AND locked_at < now() - make_interval(secs => $3)`,
+		EstadoPendiente, EstadoEnCurso, vencimiento.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// ReclamarTrabajo toma los jobs vencidos de un DNI y los marca running a
+// nombre de trabajador. Todas las filas se bloquean con SKIP LOCKED, así que
+// varios procesos pueden reclamar a la vez sin pisarse ni bloquearse. Devuelve
+// nil si no queda nada por hacer ahora.
+func ReclamarTrabajo(ctx context.Context, db *sql.DB, trabajador string) (*Trabajo, error) {
+	rows, err := db.QueryContext(ctx, `
+		WITH primero AS (
+			SELECT dni FROM enrichment_jobs
+			WHERE status = $2 AND next_attempt_at <= now()
+			ORDER BY next_attempt_at, dni
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		), elegidos AS (
+			SELECT j.dni, j.source FROM enrichment_jobs j
+			JOIN primero USING (dni)
+			WHERE j.status = $2 AND j.next_attempt_at <= now()
+			FOR UPDATE OF j SKIP LOCKED
+		)
+		UPDATE enrichment_jobs j
+		SET status = $3, attempts = j.attempts + 1,
+		    locked_by = $1, locked_at = now(), updated_at = now()
+		FROM elegidos e
+		WHERE j.dni = e.dni AND j.source = e.source
+		RETURNING j.dni, j.source, j.attempts`,
+		trabajador, EstadoPendiente, EstadoEnCurso)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var t *Trabajo
+	for rows.Next() {
+		var dni, fuente string
+		var intentos int
+		if err := rows.Scan(&dni, &fuente, &intentos); err != nil {
+			return nil, err
+		}
+		if t == nil {
+			t = &Trabajo{DNI: dni, Intentos: make(map[string]int), Trabajador: trabajador}
+		}
+		t.Fuentes = append(t.Fuentes, fuente)
+		t.Intentos[fuente] = intentos
+	}
+	return t, rows.Err()
+}
+
+// CompletarTrabajo guarda el enriquecimiento y cierra los jobs del trabajo en
+// una sola transacción, de modo que un corte nunca deja datos escritos con el
+// job todavía pendiente ni al revés. El resultado de cada fuente consultada
+// queda en lookup_outcomes. Las fuentes que fallaron se reprograman según la
+// política o pasan a failed (de inmediato si el DNI no existe en la fuente);
+// el resto queda done, hayan aportado datos o no.
+func CompletarTrabajo(ctx context.Context, db *sql.DB, t *Trabajo, e *Enriquecimiento, politica PoliticaCola) error {
+	defer medirEscritura("completar_trabajo", time.Now())
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := guardarEnriquecimientoTx(ctx, tx, e); err != nil {
+		return err
+	}
+
+	for _, fuente := range e.Consultadas {
+		if err := registrarResultado(ctx, tx, t.DNI, fuente, e.Errores[fuente], politica.Reconsulta); err != nil {
+			return err
+		}
+	}
+
+	for _, fuente := range t.Fuentes {
+		causa, fallo := e.Errores[fuente]
+		switch {
+		case fallo && ClasificarResultado(causa) == ResultadoNoEncontrado:
+			err = cerrarJob(ctx, tx, t, fuente, EstadoFallido, causa)
+		case fallo:
+			err = reprogramarJob(ctx, tx, t, fuente, causa, politica)
+		default:
+			err = cerrarJob(ctx, tx, t, fuente, EstadoHecho, nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// FallarTrabajo reprograma todos los jobs del trabajo con el mismo error,
+// p. ej. cuando no se pudo leer la fila
+func FallarTrabajo(ctx context.Context, db *sql.DB, t *Trabajo, causa error, politica PoliticaCola) error {
+	defer medirEscritura("fallar_trabajo", time.Now())
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, fuente := range t.Fuentes {
+		if err := reprogramarJob(ctx, tx, t, fuente, causa, politica); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func reprogramarJob(ctx context.Context, tx *sql.Tx, t *Trabajo, fuente string, causa error, politica PoliticaCola) error {
+	intentos := t.Intentos[fuente]
+	if intentos >= politica.MaxIntentos {
+		return cerrarJob(ctx, tx, t, fuente, EstadoFallido, causa)
+	}
+	_, err := tx.ExecContext(ctx, `
+		UPDATE enrichment_jobs
+		SET status = $1, last_error = $2, locked_by = NULL, locked_at = NULL,
+		    next_attempt_at = now() + make_interval(secs => $3), updated_at = now()
+		WHERE dni = $4 AND source = $5 AND locked_by = $6`,
+		EstadoPendiente, causa.Error(), politica.Espera(intentos).Seconds(), t.DNI, fuente, t.Trabajador)
+	return err
+}
+
+func cerrarJob(ctx context.Context, tx *sql.Tx, t *Trabajo, fuente, estado string, causa error) error {
+	var ultimo sql.NullString
+	if causa != nil {
+		ultimo = sql.NullString{String: causa.Error(), Valid: true}
+	}
+	_, err := tx.ExecContext(ctx, `
+		UPDATE enrichment_jobs
+		SET status = $1, last_error = $2, locked_by = NULL, locked_at = NULL, updated_at = now()
+		WHERE dni = $3 AND source = $4 AND locked_by = $5`,
+		estado, ultimo, t.DNI, fuente, t.Trabajador)
+	return err
+}
+
+// ContarTrabajos devuelve cuántos jobs hay en cada estado
+func ContarTrabajos(ctx context.Context, db *sql.DB) (map[string]int, error) {
+	rows, err := db.QueryContext(ctx, "SELECT status, count(*) FROM enrichment_jobs GROUP BY status")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	conteo := make(map[string]int)
+	for rows.Next() {
+		var estado string
+		var n int
+		if err := rows.Scan(&estado, &n); err != nil {
+			return nil, err
+		}
+		conteo[estado] = n
+	}
+	return conteo, rows.Err()
+}
@@ -0,0 +1,348 @@
+package core
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ArchivoConfigDefecto se lee si existe y no se indicó otro archivo
+const ArchivoConfigDefecto = "personas.yaml"
+
+// Config agrupa todo lo que antes estaba fijo en el código. Se arma en capas:
+// valores por defecto < archivo YAML < variables de entorno < flags.
+type Config struct {
+	DB      DBConfig      `yaml:"database"`
+	Workers int           `yaml:"workers"`
+	Timeout time.Duration `yaml:"timeout"` // timeout de cada cliente HTTP
+	Pausa   time.Duration `yaml:"pausa"`   // pausa base entre consultas de un worker
+	URLs    URLs          `yaml:"urls"`
+	Proxy   string        `yaml:"proxy"` // proxy HTTP de salida; vacío usa $HTTPS_PROXY
+
+	Reconsulta Reconsulta        `yaml:"reconsulta"` // espera por resultado antes de reconsultar un DNI
+	Limites    map[string]Cuota  `yaml:"limites"`    // cuota de cada fuente, por nombre
+	Reintentos PoliticaReintento `yaml:"reintentos"` // reintentos de cada consulta
+	Lotes      LotesConfig       `yaml:"lotes"`      // agrupación de las escrituras de resultados
+	Log        LogConfig         `yaml:"log"`
+	Metricas   string            `yaml:"metricas"` // dirección donde servir /metrics; vacío no las sirve
+	Reglas     string            `yaml:"reglas"`   // directorio con reglas de extracción que reemplazan a las embebidas
+
+	// Extraccion son las reglas ya cargadas y validadas
+	Extraccion Reglas `yaml:"-"`
+}
+
+// URLs de los sitios consultados; se pueden apuntar a espejos o servidores locales
+type URLs struct {
+	EldniDatos    string `yaml:"eldni_datos"`
+	EldniDigito   string `yaml:"eldni_digito"`
+	DniperuPagina string `yaml:"dniperu_pagina"`
+	DniperuAjax   string `yaml:"dniperu_ajax"`
+}
+
+// ConfigDefecto devuelve los valores que usaban los programas originalmente,
+// salvo la contraseña de la base, que no tiene valor por defecto
+func ConfigDefecto() Config {
+	return Config{
+		DB: DBConfig{
+			Host:    "localhost",
+			Port:    5433,
+			User:    "postgres",
+			DBName:  "personas",
+			SSLMode: "disable",
+		},
+		Workers: 1,
+		Timeout: 30 * time.Second,
+		Pausa:   time.Second,
+		URLs: URLs{
+			EldniDatos:    URLEldniDatos,
+			EldniDigito:   URLEldniDigito,
+			DniperuPagina: URLDniperuPagina,
+			DniperuAjax:   URLDniperuAjax,
+		},
+		Reconsulta: ReconsultaDefecto(),
+		Limites:    LimitesDefecto(),
+		Reintentos: PoliticaReintentoDefecto(),
+		Lotes:      LotesDefecto(),
+	}
+}
+
+// CargarConfig completa base con el archivo de configuración, el entorno y
+// los flags de args. Devuelve los argumentos que quedan tras los flags
+// (por ejemplo un subcomando).
+func CargarConfig(programa string, args []string, base Config) (Config, []string, error) {
+	fs := flag.NewFlagSet(programa, flag.ContinueOnError)
+	archivo := fs.String("config", "", "archivo YAML de configuración (o $PERSONAS_CONFIG)")
+
+	// Los flags se registran sobre una copia para saber luego cuáles se usaron
+	var f Config
+	fs.StringVar(&f.DB.URL, "database-url", "", "URL de conexión a PostgreSQL (o $DATABASE_URL)")
+	fs.StringVar(&f.DB.Host, "db-host", "", "host de PostgreSQL")
+	fs.IntVar(&f.DB.Port, "db-port", 0, "puerto de PostgreSQL")
+	fs.StringVar(&f.DB.User, "db-user", "", "usuario de PostgreSQL")
+	fs.StringVar(&f.DB.Password, "db-password", "", "contraseña de PostgreSQL")
+	fs.StringVar(&f.DB.DBName, "db-name", "", "base de datos")
+	fs.StringVar(&f.DB.SSLMode, "db-sslmode", "", "sslmode (disable, require, verify-ca, verify-full)")
+	fs.IntVar(&f.Workers, "workers", 0, "cantidad de workers")
+	fs.DurationVar(&f.Timeout, "timeout", 0, "timeout de cada petición HTTP")
+	fs.DurationVar(&f.Pausa, "pausa", 0, "pausa base entre consultas")
+	fs.StringVar(&f.URLs.EldniDatos, "url-eldni-datos", "", "formulario de nombres de eldni.com")
+	fs.StringVar(&f.URLs.EldniDigito, "url-eldni-digito", "", "formulario de dígito verificador de eldni.com")
+	fs.StringVar(&f.URLs.DniperuPagina, "url-dniperu-pagina", "", "página con el nonce de dniperu.com")
+	fs.StringVar(&f.URLs.DniperuAjax, "url-dniperu-ajax", "", "endpoint admin-ajax.php de dniperu.com")
+	fs.StringVar(&f.Proxy, "proxy", "", "proxy HTTP de salida (o $PERSONAS_PROXY)")
+	fs.StringVar(&f.Metricas, "metricas", "", "dirección donde servir /metrics, p. ej. :9090 (o $PERSONAS_METRICAS)")
+	fs.StringVar(&f.Reglas, "reglas", "", "directorio con reglas de extracción <fuente>.yaml (o $PERSONAS_REGLAS)")
+	fs.DurationVar(&f.Reconsulta.NoEncontrado, "reconsulta-no-encontrado", 0, "espera antes de reconsultar un DNI no encontrado")
+	fs.DurationVar(&f.Reconsulta.AccesoDenegado, "reconsulta-acceso-denegado", 0, "espera antes de reconsultar tras un acceso denegado")
+	fs.DurationVar(&f.Reconsulta.ErrorParseo, "reconsulta-error-parseo", 0, "espera antes de reconsultar tras una respuesta ilegible")
+	fs.DurationVar(&f.Reconsulta.ErrorTransporte, "reconsulta-error-transporte", 0, "espera antes de reconsultar tras un error de red")
+	fs.IntVar(&f.Reintentos.MaxIntentos, "reintentos", 0, "intentos por consulta, contando el primero")
+	fs.DurationVar(&f.Reintentos.Plazo, "plazo-reintentos", 0, "tiempo máximo de una consulta con todos sus reintentos")
+	fs.IntVar(&f.Lotes.Tamano, "lote", 0, "resultados que se guardan juntos en una transacción")
+	fs.DurationVar(&f.Lotes.Intervalo, "lote-intervalo", 0, "tiempo máximo que un resultado espera a completar su lote")
+	fs.StringVar(&f.Log.Formato, "log-format", "", "formato de los logs: text o json")
+	fs.StringVar(&f.Log.Nivel, "log-level", "", "nivel mínimo de log: debug, info, warn o error")
+	fs.BoolVar(&f.Log.Silencioso, "quiet", false, "mostrar solo errores, el panel de progreso y el resumen")
+	f.Limites = make(map[string]Cuota)
+	fs.Var(limitesFlag(f.Limites), "limite", "cuota de una fuente como fuente=por_minuto[:rafaga] (repetible)")
+
+	if err := fs.Parse(args); err != nil {
+		return base, nil, err
+	}
+
+	cfg := base
+	cfg.Limites = make(map[string]Cuota, len(base.Limites))
+	for fuente, c := range base.Limites {
+		cfg.Limites[fuente] = c
+	}
+
+	ruta := *archivo
+	if ruta == "" {
+		ruta = os.Getenv("PERSONAS_CONFIG")
+	}
+	if err := leerArchivoConfig(ruta, &cfg); err != nil {
+		return base, nil, err
+	}
+
+	if err := aplicarEntorno(&cfg); err != nil {
+		return base, nil, err
+	}
+
+	fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "database-url":
+			cfg.DB.URL = f.DB.URL
+		case "db-host":
+			cfg.DB.Host = f.DB.Host
+		case "db-port":
+			cfg.DB.Port = f.DB.Port
+		case "db-user":
+			cfg.DB.User = f.DB.User
+		case "db-password":
+			cfg.DB.Password = f.DB.Password
+		case "db-name":
+			cfg.DB.DBName = f.DB.DBName
+		case "db-sslmode":
+			cfg.DB.SSLMode = f.DB.SSLMode
+		case "workers":
+			cfg.Workers = f.Workers
+		case "timeout":
+			cfg.Timeout = f.Timeout
+		case "pausa":
+			cfg.Pausa = f.Pausa
+		case "url-eldni-datos":
+			cfg.URLs.EldniDatos = f.URLs.EldniDatos
+		case "url-eldni-digito":
+			cfg.URLs.EldniDigito = f.URLs.EldniDigito
+		case "url-dniperu-pagina":
+			cfg.URLs.DniperuPagina = f.URLs.DniperuPagina
+		case "url-dniperu-ajax":
+			cfg.URLs.DniperuAjax = f.URLs.DniperuAjax
+		case "proxy":
+			cfg.Proxy = f.Proxy
+		case "metricas":
+			cfg.Metricas = f.Metricas
+		case "reglas":
+			cfg.Reglas = f.Reglas
+		case "reconsulta-no-encontrado":
+			cfg.Reconsulta.NoEncontrado = f.Reconsulta.NoEncontrado
+		case "reconsulta-acceso-denegado":
+			cfg.Reconsulta.AccesoDenegado = f.Reconsulta.AccesoDenegado
+		case "reconsulta-error-parseo":
+			cfg.Reconsulta.ErrorParseo = f.Reconsulta.ErrorParseo
+		case "reconsulta-error-transporte":
+			cfg.Reconsulta.ErrorTransporte = f.Reconsulta.ErrorTransporte
+		case "reintentos":
+			cfg.Reintentos.MaxIntentos = f.Reintentos.MaxIntentos
+		case "plazo-reintentos":
+			cfg.Reintentos.Plazo = f.Reintentos.Plazo
+		case "lote":
+			cfg.Lotes.Tamano = f.Lotes.Tamano
+		case "lote-intervalo":
+			cfg.Lotes.Intervalo = f.Lotes.Intervalo
+		case "log-format":
+			cfg.Log.Formato = f.Log.Formato
+		case "log-level":
+			cfg.Log.Nivel = f.Log.Nivel
+		case "quiet":
+			cfg.Log.Silencioso = f.Log.Silencioso
+		case "limite":
+			for fuente, c := range f.Limites {
+				cfg.Limites[fuente] = c
+			}
+		}
+	})
+
+	if cfg.Workers < 1 {
+		return base, nil, fmt.Errorf("workers debe ser al menos 1")
+	}
+	if cfg.Lotes.Tamano < 1 {
+		return base, nil, fmt.Errorf("lote debe ser al menos 1")
+	}
+	if cfg.Proxy != "" {
+		if _, err := url.Parse(cfg.Proxy); err != nil {
+			return base, nil, fmt.Errorf("proxy inválido: %v", err)
+		}
+	}
+	reglas, err := CargarReglas(cfg.Reglas)
+	if err != nil {
+		return base, nil, err
+	}
+	cfg.Extraccion = reglas
+
+	return cfg, fs.Args(), nil
+}
+
+// OpcionesRed son las opciones comunes para crear una fuente según cfg:
+// URLs, timeout, proxy, reglas de extracción y el limitador l (que puede
+// ser nil)
+func (cfg Config) OpcionesRed(l *Limitador) []Opcion {
+	opts := []Opcion{ConURLs(cfg.URLs), ConTimeout(cfg.Timeout), ConLimitador(l), ConReglas(cfg.Extraccion)}
+	if cfg.Proxy != "" {
+		proxy, _ := url.Parse(cfg.Proxy)
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		tr.Proxy = http.ProxyURL(proxy)
+		opts = append(opts, ConTransporte(tr))
+	}
+	return opts
+}
+
+// limitesFlag acumula valores de -limite con la forma fuente=por_minuto[:rafaga]
+type limitesFlag map[string]Cuota
+
+func (l limitesFlag) String() string { return "" }
+
+func (l limitesFlag) Set(valor string) error {
+	fuente, cuota, ok := strings.Cut(valor, "=")
+	if !ok || fuente == "" {
+		return fmt.Errorf("se esperaba fuente=por_minuto[:rafaga]")
+	}
+	porMinuto, rafaga, _ := strings.Cut(cuota, ":")
+
+	var c Cuota
+	var err error
+	if c.PorMinuto, err = strconv.ParseFloat(porMinuto, 64); err != nil {
+		return fmt.Errorf("por_minuto inválido: %v", err)
+	}
+	if rafaga != "" {
+		if c.Rafaga, err = strconv.Atoi(rafaga); err != nil {
+			return fmt.Errorf("rafaga inválida: %v", err)
+		}
+	}
+	l[fuente] = c
+	return nil
+}
+
+// leerArchivoConfig superpone el YAML sobre cfg. Sin ruta explícita solo se
+// usa ArchivoConfigDefecto cuando existe.
+func leerArchivoConfig(ruta string, cfg *Config) error {
+	explicita := ruta != ""
+	if !explicita {
+		ruta = ArchivoConfigDefecto
+	}
+
+	data, err := os.ReadFile(ruta)
+	if err != nil {
+		if !explicita && errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("error leyendo configuración: %v", err)
+	}
+
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return fmt.Errorf("error en %s: %v", ruta, err)
+	}
+	return nil
+}
+
+func aplicarEntorno(cfg *Config) error {
+	textos := map[string]*string{
+		"DATABASE_URL":                &cfg.DB.URL,
+		"PERSONAS_DB_HOST":            &cfg.DB.Host,
+		"PERSONAS_DB_USER":            &cfg.DB.User,
+		"PERSONAS_DB_PASSWORD":        &cfg.DB.Password,
+		"PERSONAS_DB_NAME":            &cfg.DB.DBName,
+		"PERSONAS_DB_SSLMODE":         &cfg.DB.SSLMode,
+		"PERSONAS_URL_ELDNI_DATOS":    &cfg.URLs.EldniDatos,
+		"PERSONAS_URL_ELDNI_DIGITO":   &cfg.URLs.EldniDigito,
+		"PERSONAS_URL_DNIPERU_PAGINA": &cfg.URLs.DniperuPagina,
+		"PERSONAS_URL_DNIPERU_AJAX":   &cfg.URLs.DniperuAjax,
+		"PERSONAS_PROXY":              &cfg.Proxy,
+		"PERSONAS_METRICAS":           &cfg.Metricas,
+		"PERSONAS_REGLAS":             &cfg.Reglas,
+		"PERSONAS_LOG_FORMAT":         &cfg.Log.Formato,
+		"PERSONAS_LOG_LEVEL":          &cfg.Log.Nivel,
+		"PERSONAS_LOG_CLAVE_DNI":      &cfg.Log.ClaveDNI,
+	}
+	for nombre, destino := range textos {
+		if v, ok := os.LookupEnv(nombre); ok {
+			*destino = v
+		}
+	}
+
+	enteros := map[string]*int{
+		"PERSONAS_DB_PORT":    &cfg.DB.Port,
+		"PERSONAS_WORKERS":    &cfg.Workers,
+		"PERSONAS_REINTENTOS": &cfg.Reintentos.MaxIntentos,
+		"PERSONAS_LOTE":       &cfg.Lotes.Tamano,
+	}
+	for nombre, destino := range enteros {
+		if v, ok := os.LookupEnv(nombre); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("%s inválido: %v", nombre, err)
+			}
+			*destino = n
+		}
+	}
+
+	duraciones := map[string]*time.Duration{
+		"PERSONAS_TIMEOUT":                     &cfg.Timeout,
+		"PERSONAS_PAUSA":                       &cfg.Pausa,
+		"PERSONAS_RECONSULTA_NO_ENCONTRADO":    &cfg.Reconsulta.NoEncontrado,
+		"PERSONAS_RECONSULTA_ACCESO_DENEGADO":  &cfg.Reconsulta.AccesoDenegado,
+		"PERSONAS_RECONSULTA_ERROR_PARSEO":     &cfg.Reconsulta.ErrorParseo,
+		"PERSONAS_RECONSULTA_ERROR_TRANSPORTE": &cfg.Reconsulta.ErrorTransporte,
+		"PERSONAS_PLAZO_REINTENTOS":            &cfg.Reintentos.Plazo,
+		"PERSONAS_LOTE_INTERVALO":              &cfg.Lotes.Intervalo,
+	}
+	for nombre, destino := range duraciones {
+		if v, ok := os.LookupEnv(nombre); ok {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("%s inválido: %v", nombre, err)
+			}
+			*destino = d
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,211 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Confianza de un campo escrito: corroborada si otra fuente (o lo que ya
+// estaba registrado) dice lo mismo; si no, la de la única fuente que lo dio
+const (
+	ConfianzaCorroborada = 1.0
+	ConfianzaFuenteUnica = 0.7
+)
+
+// FuenteRegistrada identifica, entre las evidencias y los candidatos, el
+// valor que ya estaba en personas
+const FuenteRegistrada = "registrado"
+
+// MotivoDiscrepancia es el motivo de revisión de un campo en el que las
+// fuentes no coinciden
+const MotivoDiscrepancia = "discrepancia"
+
+// MetodoNombreCompleto marca un candidato que es el nombre completo sin
+// separar en nombres y apellidos
+const MetodoNombreCompleto = "nombre_completo"
+
+// CamposNombre son los campos que se contrastan entre fuentes
+var CamposNombre = []Campo{CampoNombres, CampoApellidoPaterno, CampoApellidoMaterno}
+
+// ConfianzaFuente es la confianza de un valor que solo dio fuente
+func ConfianzaFuente(fuente string) float64 {
+	if fuente == NombreDigitoLocal {
+		// Se calcula, no se extrae de una página
+		return ConfianzaCorroborada
+	}
+	return ConfianzaFuenteUnica
+}
+
+// Evidencia es lo que una fuente dice del nombre de una persona: los campos
+// por separado o, si la fuente solo da eso, el nombre completo
+type Evidencia struct {
+	Fuente         string
+	Persona        Persona
+	NombreCompleto string
+}
+
+// Candidato es uno de los valores posibles de un campo en revisión
+type Candidato struct {
+	Fuente string
+	Valor  string
+	Metodo string // cómo se obtuvo el valor; vacío si no se sabe
+}
+
+// Conflicto es un campo que queda en revisión en vez de escribirse
+type Conflicto struct {
+	DNI        string
+	Campo      Campo
+	Motivo     string
+	Candidatos []Candidato
+}
+
+var sinTildes = strings.NewReplacer(
+	"Á", "A", "À", "A", "Ä", "A", "Â", "A",
+	"É", "E", "È", "E", "Ë", "E", "Ê", "E",
+	"Í", "I", "Ì", "I", "Ï", "I", "Î", "I",
+	"Ó", "O", "Ò", "O", "Ö", "O", "Ô", "O",
+	"Ú", "U", "Ù", "U", "Ü", "U", "Û", "U",
+	"Ñ", "N", "Ç", "C",
+)
+
+// NormalizarNombre pasa s a mayúsculas sin tildes, con los signos como
+// espacios y un solo espacio entre palabras
+func NormalizarNombre(s string) string {
+	s = sinTildes.Replace(strings.ToUpper(s))
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return ' '
+	}, s)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// MismoNombre indica si a y b tienen las mismas palabras, en cualquier orden
+func MismoNombre(a, b string) bool {
+	pa, pb := palabras(a), palabras(b)
+	if len(pa) != len(pb) {
+		return false
+	}
+	for i := range pa {
+		if pa[i] != pb[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// contenidoEn indica si todas las palabras de parte aparecen en completo
+func contenidoEn(parte, completo string) bool {
+	cuenta := make(map[string]int)
+	for _, p := range palabras(completo) {
+		cuenta[p]++
+	}
+	for _, p := range palabras(parte) {
+		if cuenta[p] == 0 {
+			return false
+		}
+		cuenta[p]--
+	}
+	return true
+}
+
+func palabras(s string) []string {
+	p := strings.Fields(NormalizarNombre(s))
+	sort.Strings(p)
+	return p
+}
+
+// ContrastarNombres compara cada campo de nombre entre las evidencias. Un
+// valor coincide con otro si tiene las mismas palabras y con un nombre
+// completo si todas sus palabras están en él. Devuelve la confianza de los
+// campos en los que todas coinciden y un conflicto por cada campo en el que
+// alguna discrepa.
+func ContrastarNombres(dni string, evidencias []Evidencia) (map[Campo]float64, []Conflicto) {
+	confianza := make(map[Campo]float64)
+	var conflictos []Conflicto
+	for _, c := range CamposNombre {
+		var valores, completos []Evidencia
+		for _, e := range evidencias {
+			switch {
+			case e.Persona.Valor(c) != "":
+				valores = append(valores, e)
+			case e.NombreCompleto != "":
+				completos = append(completos, e)
+			}
+		}
+		if len(valores) == 0 {
+			continue
+		}
+
+		coinciden := true
+		referencia := valores[0].Persona.Valor(c)
+		for _, e := range valores[1:] {
+			coinciden = coinciden && MismoNombre(referencia, e.Persona.Valor(c))
+		}
+		for _, e := range completos {
+			coinciden = coinciden && contenidoEn(referencia, e.NombreCompleto)
+		}
+
+		if !coinciden {
+			conflicto := Conflicto{DNI: dni, Campo: c, Motivo: MotivoDiscrepancia}
+			for _, e := range valores {
+				conflicto.Candidatos = append(conflicto.Candidatos,
+					Candidato{Fuente: e.Fuente, Valor: e.Persona.Valor(c), Metodo: e.Persona.Extraccion[c]})
+			}
+			for _, e := range completos {
+				conflicto.Candidatos = append(conflicto.Candidatos,
+					Candidato{Fuente: e.Fuente, Valor: e.NombreCompleto, Metodo: MetodoNombreCompleto})
+			}
+			conflictos = append(conflictos, conflicto)
+			continue
+		}
+		if len(valores)+len(completos) > 1 {
+			confianza[c] = ConfianzaCorroborada
+		} else {
+			confianza[c] = ConfianzaFuente(valores[0].Fuente)
+		}
+	}
+	return confianza, conflictos
+}
+
+// evidenciaRegistrada son los nombres que p ya tiene en personas
+func evidenciaRegistrada(p Persona) Evidencia {
+	return Evidencia{Fuente: FuenteRegistrada, Persona: Persona{
+		DNI: p.DNI, Nombres: p.Nombres, ApellidoPaterno: p.ApellidoPaterno, ApellidoMaterno: p.ApellidoMaterno,
+	}}
+}
+
+// registrarConflictos deja cada conflicto en personas_review con sus
+// candidatos. Si el campo ya tiene una revisión pendiente, los candidatos
+// nuevos se suman a esa.
+func registrarConflictos(ctx context.Context, ex ejecutor, conflictos []Conflicto) error {
+	for _, c := range conflictos {
+		var filas []string
+		args := []interface{}{c.DNI, string(c.Campo), c.Motivo, IDCorrida()}
+		for _, candidato := range c.Candidatos {
+			n := len(args) + 1
+			filas = append(filas, fmt.Sprintf("($%d::text, $%d::text, $%d::text)", n, n+1, n+2))
+			args = append(args, candidato.Fuente, candidato.Valor, nulo(candidato.Metodo))
+		}
+		_, err := ex.ExecContext(ctx, `
+			WITH r AS (
+				INSERT INTO personas_review (dni, field, reason, run_id)
+				VALUES ($1, $2, $3, $4)
+				ON CONFLICT (dni, field) WHERE status = 'pending'
+				DO UPDATE SET reason = EXCLUDED.reason
+				RETURNING id
+			)
+			INSERT INTO personas_review_candidates (review_id, source, value, method)
+			SELECT r.id, c.source, c.value, c.method
+			FROM r, (VALUES `+strings.Join(filas, ", ")+`) AS c (source, value, method)
+			ON CONFLICT (review_id, source, value) DO NOTHING`, args...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package core
+
+import "testing"
+
+func TestNormalizarNombre(t *testing.T) {
+	casos := map[string]string{
+		"José Luis":         "JOSE LUIS",
+		"  DE LA   CRUZ ":   "DE LA CRUZ",
+		"Peña-Ñahui":        "PENA NAHUI",
+		"o'higgins, maría":  "O HIGGINS MARIA",
+		"MÜLLER\tÇELIK":     "MULLER CELIK",
+		"":                  "",
+		" \t ":              "",
+		"Quispe Mamani":     "QUISPE MAMANI",
+		"ÁÉÍÓÚ àèìòù âêîôû": "AEIOU AEIOU AEIOU",
+	}
+	for entrada, esperado := range casos {
+		if obtenido := NormalizarNombre(entrada); obtenido != esperado {
+			t.Errorf("NormalizarNombre(%q) = %q, se esperaba %q", entrada, obtenido, esperado)
+		}
+	}
+}
+
+func TestMismoNombre(t *testing.T) {
+	casos := []struct {
+		a, b   string
+		mismo  bool
+		motivo string
+	}{
+		{"JOSÉ LUIS", "Jose Luis", true, "tildes y mayúsculas"},
+		{"DE LA CRUZ", "  de  la cruz ", true, "espacios"},
+		{"LUIS JOSÉ", "JOSE LUIS", true, "orden"},
+		{"JOSÉ", "JOSÉ LUIS", false, "falta una palabra"},
+		{"JUAN", "JUANA", false, "otro nombre"},
+		{"ANA ANA", "ANA", false, "palabra repetida"},
+	}
+	for _, c := range casos {
+		if MismoNombre(c.a, c.b) != c.mismo {
+			t.Errorf("%s: MismoNombre(%q, %q) = %v", c.motivo, c.a, c.b, !c.mismo)
+		}
+	}
+}
+
+func TestContrastarNombres(t *testing.T) {
+	registrado := Evidencia{Fuente: FuenteRegistrada, Persona: Persona{
+		Nombres: "JUAN CARLOS", ApellidoPaterno: "PÉREZ", ApellidoMaterno: "DE LA CRUZ",
+	}}
+
+	casos := []struct {
+		motivo     string
+		evidencia  Evidencia
+		discrepan  []Campo
+		candidatos int
+	}{
+		{
+			motivo: "sin tildes ni mayúsculas",
+			evidencia: Evidencia{Fuente: NombreEldniDatos, Persona: Persona{
+				Nombres: "Juan Carlos", ApellidoPaterno: "Perez", ApellidoMaterno: "de la Cruz",
+			}},
+		},
+		{
+			motivo: "espacios de más",
+			evidencia: Evidencia{Fuente: NombreEldniDatos, Persona: Persona{
+				Nombres: " JUAN  CARLOS", ApellidoPaterno: "PÉREZ ", ApellidoMaterno: "DE  LA CRUZ",
+			}},
+		},
+		{
+			motivo:    "nombre completo con los apellidos primero",
+			evidencia: Evidencia{Fuente: NombreDniperu, NombreCompleto: "Perez De La Cruz, Juan Carlos"},
+		},
+		{
+			motivo: "otro nombre",
+			evidencia: Evidencia{Fuente: NombreEldniDatos, Persona: Persona{
+				Nombres: "JUAN PABLO", ApellidoPaterno: "PEREZ", ApellidoMaterno: "DE LA CRUZ",
+			}},
+			discrepan:  []Campo{CampoNombres},
+			candidatos: 2,
+		},
+		{
+			motivo:     "nombre completo que no contiene el apellido",
+			evidencia:  Evidencia{Fuente: NombreDniperu, NombreCompleto: "PEREZ CRUZ JUAN CARLOS"},
+			discrepan:  []Campo{CampoApellidoMaterno},
+			candidatos: 2,
+		},
+	}
+	for _, c := range casos {
+		t.Run(c.motivo, func(t *testing.T) {
+			confianza, conflictos := ContrastarNombres("45678912", []Evidencia{registrado, c.evidencia})
+			if len(conflictos) != len(c.discrepan) {
+				t.Fatalf("%d conflictos, se esperaban %d: %+v", len(conflictos), len(c.discrepan), conflictos)
+			}
+			enConflicto := make(map[Campo]bool)
+			for i, conflicto := range conflictos {
+				enConflicto[conflicto.Campo] = true
+				if conflicto.Campo != c.discrepan[i] || conflicto.Motivo != MotivoDiscrepancia ||
+					len(conflicto.Candidatos) != c.candidatos || conflicto.Candidatos[0].Fuente != FuenteRegistrada {
+					t.Errorf("conflicto inesperado: %+v", conflicto)
+				}
+			}
+			for _, campo := range CamposNombre {
+				_, ok := confianza[campo]
+				switch {
+				case enConflicto[campo] && ok:
+					t.Errorf("%s está en conflicto y tiene confianza", campo)
+				case !enConflicto[campo] && confianza[campo] != ConfianzaCorroborada:
+					t.Errorf("%s: confianza %v, se esperaba corroborada", campo, confianza[campo])
+				}
+			}
+		})
+	}
+
+	// Una sola fuente, sin nada con qué contrastar
+	confianza, conflictos := ContrastarNombres("45678912", []Evidencia{{Fuente: NombreEldniDatos, Persona: Persona{Nombres: "JUAN"}}})
+	if len(conflictos) != 0 || confianza[CampoNombres] != ConfianzaFuenteUnica {
+		t.Errorf("fuente única: confianza %v, conflictos %+v", confianza, conflictos)
+	}
+}
@@ -0,0 +1,126 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"time"
+)
+
+// Pesos aplicados a cada uno de los 8 dígitos del DNI
+var pesosDNI = [8]int{3, 2, 7, 6, 5, 4, 3, 2}
+
+// Tablas de equivalencia indexadas por 11 - (suma % 11), usando 0 cuando vale 11
+var (
+	digitosVerificadores = [11]byte{'6', '7', '8', '9', '0', '1', '1', '2', '3', '4', '5'}
+	letrasVerificadoras  = [11]byte{'K', 'A', 'B', 'C', 'D', 'E', 'F', 'G', 'H', 'I', 'J'}
+)
+
+var reDNI = regexp.MustCompile(`^[0-9]{8}$`)
+
+// CalcularDigitoVerificador calcula localmente el dígito verificador del DNI
+// con el algoritmo de módulo 11 de RENIEC. Devuelve la variante numérica
+// (la que se guarda en codigo_verificador) y la variante en letra.
+func CalcularDigitoVerificador(dni string) (numero, letra string, err error) {
+	if !reDNI.MatchString(dni) {
+		return "", "", ErrInvalidDNI
+	}
+
+	suma := 0
+	for i, peso := range pesosDNI {
+		suma += int(dni[i]-'0') * peso
+	}
+
+	indice := 11 - suma%11
+	if indice == 11 {
+		indice = 0
+	}
+
+	return string(digitosVerificadores[indice]), string(letrasVerificadoras[indice]), nil
+}
+
+// RegistroCodigo es un código verificador ya guardado en la BD
+type RegistroCodigo struct {
+	DNI    string
+	Codigo string
+}
+
+// Discrepancia entre el código guardado y el calculado localmente
+type Discrepancia struct {
+	DNI        string
+	Registrado string
+	Esperado   string
+}
+
+func ObtenerCodigosRegistrados(ctx context.Context, db *sql.DB) ([]RegistroCodigo, error) {
+	query := `
+		SELECT dni, codigo_verificador FROM personas
+		WHERE codigo_verificador IS NOT NULL
+		ORDER BY id`
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var registros []RegistroCodigo
+	for rows.Next() {
+		var r RegistroCodigo
+		if err := rows.Scan(&r.DNI, &r.Codigo); err != nil {
+			return nil, err
+		}
+		registros = append(registros, r)
+	}
+	return registros, rows.Err()
+}
+
+// VerificarCodigos recalcula cada código y devuelve los que no coinciden.
+// Los DNIs con formato inválido se devuelven aparte porque no tienen esperado.
+func VerificarCodigos(registros []RegistroCodigo) (discrepancias []Discrepancia, invalidos []string) {
+	for _, r := range registros {
+		esperado, _, err := CalcularDigitoVerificador(r.DNI)
+		if err != nil {
+			invalidos = append(invalidos, r.DNI)
+			continue
+		}
+		if r.Codigo != esperado {
+			discrepancias = append(discrepancias, Discrepancia{DNI: r.DNI, Registrado: r.Codigo, Esperado: esperado})
+		}
+	}
+	return discrepancias, invalidos
+}
+
+// CorregirCodigos reemplaza los códigos erróneos en una sola transacción
+func CorregirCodigos(ctx context.Context, db *sql.DB, discrepancias []Discrepancia) error {
+	defer medirEscritura("corregir_codigos", time.Now())
+	return ActualizarCodigosVerificacion(ctx, db, codigosCorregidos(discrepancias), NombreDigitoLocal)
+}
+
+// codigosCorregidos da el código esperado de cada DNI con discrepancia
+func codigosCorregidos(discrepancias []Discrepancia) map[string]string {
+	codigos := make(map[string]string, len(discrepancias))
+	for _, d := range discrepancias {
+		codigos[d.DNI] = d.Esperado
+	}
+	return codigos
+}
+
+// NombreDigitoLocal es el nombre de la fuente que calcula el dígito sin red
+const NombreDigitoLocal = "digito_local"
+
+// FuenteDigitoLocal expone CalcularDigitoVerificador como Source para que el
+// orquestador la prefiera antes de consultar eldni.com
+type FuenteDigitoLocal struct{}
+
+func (FuenteDigitoLocal) Name() string { return NombreDigitoLocal }
+
+func (FuenteDigitoLocal) Fields() []Campo { return []Campo{CampoCodigoVerif} }
+
+func (FuenteDigitoLocal) Lookup(ctx context.Context, dni string) (*Persona, error) {
+	numero, _, err := CalcularDigitoVerificador(dni)
+	if err != nil {
+		return nil, err
+	}
+	return &Persona{DNI: dni, CodigoVerif: numero}, nil
+}
@@ -0,0 +1,69 @@
+package core
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// Cada DNI cae en una posición distinta de las tablas de equivalencia, así
+// que entre todos las recorren enteras
+func TestCalcularDigitoVerificador(t *testing.T) {
+	casos := []struct {
+		dni, numero, letra string
+	}{
+		{"00000000", "6", "K"}, // suma 0: la posición 11 vale 0
+		{"00000005", "7", "A"},
+		{"00000030", "8", "B"},
+		{"00000004", "9", "C"},
+		{"00100000", "0", "D"},
+		{"00000003", "1", "E"},
+		{"00001000", "1", "F"},
+		{"00000002", "2", "G"},
+		{"10000000", "3", "H"},
+		{"00000001", "4", "I"},
+		{"00000006", "5", "J"},
+		{"12345678", "1", "E"},
+		{"45678912", "4", "I"},
+		{"87654321", "0", "D"},
+		{"99999999", "4", "I"},
+	}
+	for _, c := range casos {
+		numero, letra, err := CalcularDigitoVerificador(c.dni)
+		if err != nil || numero != c.numero || letra != c.letra {
+			t.Errorf("%s: %q %q %v, se esperaba %q %q", c.dni, numero, letra, err, c.numero, c.letra)
+		}
+	}
+}
+
+func TestCalcularDigitoVerificadorInvalido(t *testing.T) {
+	for _, dni := range []string{"", "1234567", "123456789", "1234567a", "1234 678", "-1234567", "１２３４５６７８"} {
+		if numero, letra, err := CalcularDigitoVerificador(dni); !errors.Is(err, ErrInvalidDNI) || numero != "" || letra != "" {
+			t.Errorf("%q: %q %q %v, se esperaba ErrInvalidDNI", dni, numero, letra, err)
+		}
+	}
+}
+
+func TestVerificarCodigos(t *testing.T) {
+	discrepancias, invalidos := VerificarCodigos([]RegistroCodigo{
+		{DNI: "12345678", Codigo: "1"},
+		{DNI: "45678912", Codigo: "9"},
+		{DNI: "1234567", Codigo: "0"},
+		{DNI: "00000000", Codigo: "K"}, // la letra no es la variante que se guarda
+	})
+	esperadas := []Discrepancia{
+		{DNI: "45678912", Registrado: "9", Esperado: "4"},
+		{DNI: "00000000", Registrado: "K", Esperado: "6"},
+	}
+	if !reflect.DeepEqual(discrepancias, esperadas) {
+		t.Errorf("discrepancias %+v, se esperaba %+v", discrepancias, esperadas)
+	}
+	if !reflect.DeepEqual(invalidos, []string{"1234567"}) {
+		t.Errorf("inválidos %v", invalidos)
+	}
+
+	codigos := codigosCorregidos(discrepancias)
+	if !reflect.DeepEqual(codigos, map[string]string{"45678912": "4", "00000000": "6"}) {
+		t.Errorf("CorregirCodigos escribiría %v", codigos)
+	}
+}
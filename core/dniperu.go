@@ -0,0 +1,240 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Páginas de dniperu.com
+const (
+	URLDniperuPagina = "https://dniperu.com/fecha-de-nacimiento-con-dni/"
+	URLDniperuAjax   = "https://dniperu.com/wp-admin/admin-ajax.php"
+)
+
+// NombreDniperu es el nombre con el que se registra DNIScraper como fuente
+const NombreDniperu = "dniperu"
+
+// EsperaLimiteDniperu es la espera tras un aviso de rate limit sin Retry-After
+const EsperaLimiteDniperu = 10 * time.Second
+
+type DNIScraper struct {
+	mu            sync.Mutex // serializa Lookup; el scraper guarda estado por sesión
+	client        *http.Client
+	reloj         Reloj
+	urlPagina     string
+	urlAjax       string
+	nonce         string
+	requestCount  int
+	sesionVencida bool // tras un rate limit se empieza con cookies nuevas
+	userAgents    []string
+	reglas        *ReglasFuente
+}
+
+// NewDNIScraper crea un scraper de dniperu.com. El ritmo de las consultas lo
+// pone el limitador: usar ConLimitador con la cuota de NombreDniperu.
+func NewDNIScraper(opts ...Opcion) *DNIScraper {
+	o := aplicarOpciones(opts)
+	userAgents := []string{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/119.0.0.0 Safari/537.36",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:121.0) Gecko/20100101 Firefox/121.0",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/605.1.15",
+		"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+	}
+
+	return &DNIScraper{
+		client:     o.nuevoCliente(),
+		reloj:      o.reloj,
+		urlPagina:  o.url(o.urls.DniperuPagina, URLDniperuPagina),
+		urlAjax:    o.url(o.urls.DniperuAjax, URLDniperuAjax),
+		userAgents: userAgents,
+		reglas:     o.reglasDe(NombreDniperu),
+	}
+}
+
+func (ds *DNIScraper) getRandomUserAgent() string {
+	n, _ := rand.Int(rand.Reader, big.NewInt(int64(len(ds.userAgents))))
+	return ds.userAgents[n.Int64()]
+}
+
+func (ds *DNIScraper) resetSession(ctx context.Context) error {
+	slog.Debug("reseteando sesión", "source", NombreDniperu)
+
+	jar, _ := cookiejar.New(nil)
+	ds.client.Jar = jar
+	ds.nonce = ""
+	ds.requestCount = 0
+	ds.sesionVencida = false
+
+	if err := ds.reloj.Dormir(ctx, 3*time.Second); err != nil {
+		return err
+	}
+
+	return ds.getNonce(ctx)
+}
+
+func (ds *DNIScraper) getNonce(ctx context.Context) (err error) {
+	defer func() { registrarRenovacion(NombreDniperu, err) }()
+	defer marcarWorker(ctx, WorkerRenovandoToken, "")()
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", ds.urlPagina, nil)
+	req.Header.Set("User-Agent", ds.getRandomUserAgent())
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,*/*;q=0.8")
+	req.Header.Set("Accept-Language", "es-ES,es;q=0.9,en;q=0.8")
+	req.Header.Set("Cache-Control", "no-cache")
+
+	resp, err := ds.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := errorDeEstado(resp, ds.reloj); err != nil {
+		return err
+	}
+
+	body, err := LeerRespuesta(resp)
+	if err != nil {
+		return err
+	}
+	if ds.nonce, err = ds.reglas.ExtraerToken(string(body)); err != nil {
+		return err
+	}
+
+	if ds.nonce == "" {
+		return fmt.Errorf("%w: no se pudo obtener el nonce", ErrTokenMissing)
+	}
+
+	slog.Debug("nonce nuevo", "source", NombreDniperu)
+	return nil
+}
+
+func (ds *DNIScraper) Name() string { return NombreDniperu }
+
+// Fields solo declara la fecha: el nombre que devuelve dniperu.com es el
+// nombre completo y no corresponde a ninguna columna por separado
+func (ds *DNIScraper) Fields() []Campo { return []Campo{CampoFechaNacimiento} }
+
+func (ds *DNIScraper) Lookup(ctx context.Context, dni string) (*Persona, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	return ds.ConsultarDNI(ctx, dni)
+}
+
+// ConsultarDNI obtiene nombre completo y fecha de nacimiento (dd/mm/aaaa)
+// en un solo intento; los reintentos los decide PoliticaReintento. Si el
+// sitio pide bajar el ritmo frena el limitador del cliente y descarta la
+// sesión para que el próximo intento empiece con una nueva.
+func (ds *DNIScraper) ConsultarDNI(ctx context.Context, dni string) (*Persona, error) {
+	persona, err := ds.consultar(ctx, dni)
+
+	var limite *RateLimitError
+	if errors.As(err, &limite) {
+		espera := limite.RetryAfter
+		if espera == 0 {
+			espera = EsperaLimiteDniperu
+			limite.RetryAfter = espera
+		}
+		FrenarCliente(ds.client, espera)
+		ds.sesionVencida = true
+	}
+	return persona, err
+}
+
+// errorDeMensaje traduce el mensaje de una respuesta no exitosa
+func errorDeMensaje(mensaje string) error {
+	if strings.Contains(strings.ToLower(mensaje), "demasiadas solicitudes") {
+		return &RateLimitError{}
+	}
+	return fmt.Errorf("%w: consulta no exitosa: %s", ErrParse, mensaje)
+}
+
+func (ds *DNIScraper) consultar(ctx context.Context, dni string) (*Persona, error) {
+	if !reDNI.MatchString(dni) {
+		return nil, ErrInvalidDNI
+	}
+
+	ds.requestCount++
+	if ds.requestCount > 4 || ds.sesionVencida {
+		if err := ds.resetSession(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	if ds.nonce == "" {
+		if err := ds.getNonce(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	data := url.Values{}
+	data.Set("dni", dni)
+	data.Set("action", "buscar_fecha")
+	data.Set("security", ds.nonce)
+	data.Set("company", "")
+
+	req, _ := http.NewRequestWithContext(ctx, "POST", ds.urlAjax, strings.NewReader(data.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=UTF-8")
+	req.Header.Set("User-Agent", ds.getRandomUserAgent())
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	req.Header.Set("Referer", ds.urlPagina)
+	req.Header.Set("Accept", "application/json, text/javascript, */*; q=0.01")
+	req.Header.Set("Accept-Language", "es-ES,es;q=0.9,en;q=0.8")
+
+	slog.Debug("consultando", "source", NombreDniperu, AtributoDNI(dni))
+
+	resp, err := ds.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	respStr := strings.TrimSpace(string(body))
+
+	switch respStr {
+	case "-1":
+		return nil, ErrAccessDenied
+	case "0":
+		return nil, ErrNotFound
+	}
+
+	if err := errorDeEstado(resp, ds.reloj); err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Success bool `json:"success"`
+		Data    struct {
+			Message string `json:"message"`
+		} `json:"data"`
+	}
+
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrParse, err)
+	}
+
+	if !response.Success {
+		return nil, errorDeMensaje(response.Data.Message)
+	}
+
+	// Los datos salen de las reglas; el JSON ya se sabe válido
+	return ds.reglas.ExtraerCampos(string(body), dni)
+}
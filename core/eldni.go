@@ -0,0 +1,263 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Formularios de eldni.com
+const (
+	URLEldniDatos  = "https://eldni.com/pe/buscar-datos-por-dni"
+	URLEldniDigito = "https://eldni.com/pe/obtener-digito-verificador-del-dni"
+)
+
+// Nombres con los que se registran las fuentes de eldni.com
+const (
+	NombreEldniDatos  = "eldni_datos"
+	NombreEldniDigito = "eldni_digito"
+)
+
+// ConsultasPorToken es cada cuántas consultas se pide un token CSRF nuevo
+const ConsultasPorToken = 3
+
+// FuenteEldniDatos consulta nombres y apellidos manteniendo su propia sesión
+// y token CSRF. Las consultas de una misma fuente se serializan, así que
+// cada worker debería tener la suya.
+type FuenteEldniDatos struct {
+	mu        sync.Mutex
+	client    *http.Client
+	targetURL string
+	reglas    *ReglasFuente
+	token     string
+	consultas int
+	reloj     Reloj
+}
+
+// NuevaFuenteEldniDatos crea la fuente con una sesión propia; sin opciones
+// consulta URLEldniDatos con un cliente nuevo sin límite de ritmo
+func NuevaFuenteEldniDatos(opts ...Opcion) *FuenteEldniDatos {
+	o := aplicarOpciones(opts)
+	return &FuenteEldniDatos{
+		client:    o.nuevoCliente(),
+		targetURL: o.url(o.urls.EldniDatos, URLEldniDatos),
+		reglas:    o.reglasDe(NombreEldniDatos),
+		reloj:     o.reloj,
+	}
+}
+
+func (f *FuenteEldniDatos) Name() string { return NombreEldniDatos }
+
+func (f *FuenteEldniDatos) Fields() []Campo {
+	return []Campo{CampoNombres, CampoApellidoPaterno, CampoApellidoMaterno}
+}
+
+// Consultas devuelve cuántas consultas hizo la fuente
+func (f *FuenteEldniDatos) Consultas() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.consultas
+}
+
+// RenovarToken descarga el formulario de nuevo para obtener otro token
+func (f *FuenteEldniDatos) RenovarToken(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.renovarToken(ctx)
+}
+
+func (f *FuenteEldniDatos) renovarToken(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	token, err := obtenerToken(ctx, f.client, f.targetURL, f.reglas, f.reloj)
+	registrarRenovacion(NombreEldniDatos, err)
+	if err != nil {
+		return err
+	}
+	f.token = token
+	return nil
+}
+
+func (f *FuenteEldniDatos) Lookup(ctx context.Context, dni string) (*Persona, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Token inicial y renovación periódica; si la renovación falla se sigue
+	// con el token actual
+	if f.token == "" || (f.consultas > 0 && f.consultas%ConsultasPorToken == 0) {
+		if err := f.renovarToken(ctx); err != nil {
+			if f.token == "" {
+				return nil, fmt.Errorf("error obteniendo token: %w", err)
+			}
+			slog.Warn("no se pudo renovar el token", "source", NombreEldniDatos, "error", err)
+		}
+	}
+
+	f.consultas++
+	persona, err := enviarDatos(ctx, f.client, f.targetURL, dni, f.token, f.reglas, f.reloj)
+
+	// Tras un rate limit o un 419 el token suele quedar invalidado: el
+	// próximo intento pide uno nuevo
+	var errHTTP *ErrorHTTP
+	if errors.Is(err, ErrRateLimited) || (errors.As(err, &errHTTP) && errHTTP.Status == 419) {
+		f.token = ""
+	}
+	return persona, err
+}
+
+// FuenteEldniDigito consulta el dígito verificador usando una sesión nueva
+// por DNI, igual que el formulario público. Todas las sesiones se arman con
+// las mismas opciones y comparten el limitador, si hay uno.
+type FuenteEldniDigito struct {
+	targetURL string
+	opciones  *opciones
+	reglas    *ReglasFuente
+}
+
+func NuevaFuenteEldniDigito(opts ...Opcion) *FuenteEldniDigito {
+	o := aplicarOpciones(opts)
+	return &FuenteEldniDigito{
+		targetURL: o.url(o.urls.EldniDigito, URLEldniDigito),
+		opciones:  o,
+		reglas:    o.reglasDe(NombreEldniDigito),
+	}
+}
+
+func (f *FuenteEldniDigito) Name() string { return NombreEldniDigito }
+
+func (f *FuenteEldniDigito) Fields() []Campo { return []Campo{CampoCodigoVerif} }
+
+func (f *FuenteEldniDigito) Lookup(ctx context.Context, dni string) (*Persona, error) {
+	client := f.opciones.nuevoCliente()
+	client.Jar, _ = cookiejar.New(nil)
+
+	codigo, estrategia, err := consultarCodigo(ctx, client, f.targetURL, dni, f.reglas, f.opciones.reloj)
+	if err != nil {
+		return nil, err
+	}
+	p := &Persona{DNI: dni}
+	p.extraido(CampoCodigoVerif, codigo, estrategia)
+	return p, nil
+}
+
+// ObtenerDatosPersona consulta nombres y apellidos en el formulario targetURL
+// (normalmente URLEldniDatos) obteniendo antes un token nuevo
+func ObtenerDatosPersona(ctx context.Context, client *http.Client, targetURL, dni string) (*Persona, error) {
+	if !reDNI.MatchString(dni) {
+		return nil, ErrInvalidDNI
+	}
+
+	// Paso 1: Obtener el token CSRF
+	token, err := ObtenerToken(ctx, client, targetURL)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo token: %w", err)
+	}
+
+	// Paso 2: Enviar formulario y obtener datos
+	return EnviarFormularioConToken(ctx, client, targetURL, dni, token)
+}
+
+// EnviarFormularioConToken envía el formulario de datos con un token ya obtenido
+func EnviarFormularioConToken(ctx context.Context, client *http.Client, targetURL, dni, token string) (*Persona, error) {
+	return enviarDatos(ctx, client, targetURL, dni, token, ReglasDefecto()[NombreEldniDatos], RelojSistema)
+}
+
+func enviarDatos(ctx context.Context, client *http.Client, targetURL, dni, token string, reglas *ReglasFuente, reloj Reloj) (*Persona, error) {
+	body, err := enviarFormulario(ctx, client, targetURL, reloj, url.Values{
+		"_token": {token},
+		"dni":    {dni},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return extraerDatosPersona(string(body), dni, reglas)
+}
+
+// ObtenerCodigoVerificacion consulta el dígito verificador en el formulario
+// targetURL (normalmente URLEldniDigito)
+func ObtenerCodigoVerificacion(ctx context.Context, client *http.Client, targetURL, dni string) (string, error) {
+	codigo, _, err := consultarCodigo(ctx, client, targetURL, dni, ReglasDefecto()[NombreEldniDigito], RelojSistema)
+	return codigo, err
+}
+
+// consultarCodigo es ObtenerCodigoVerificacion con reglas, junto con la
+// estrategia que encontró el dígito en la página
+func consultarCodigo(ctx context.Context, client *http.Client, targetURL, dni string, reglas *ReglasFuente, reloj Reloj) (string, string, error) {
+	if !reDNI.MatchString(dni) {
+		return "", "", ErrInvalidDNI
+	}
+
+	token, err := obtenerToken(ctx, client, targetURL, reglas, reloj)
+	registrarRenovacion(NombreEldniDigito, err)
+	if err != nil {
+		return "", "", err
+	}
+
+	body, err := enviarFormulario(ctx, client, targetURL, reloj, url.Values{
+		"_token":  {token},
+		"dniveri": {dni},
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	datos, err := reglas.ExtraerCampos(string(body), dni)
+	if err != nil {
+		return "", "", err
+	}
+	codigo, estrategia := datos.CodigoVerif, datos.Extraccion[CampoCodigoVerif]
+	if codigo == "" {
+		return "", "", fmt.Errorf("%w: código verificador no encontrado en la página", ErrParse)
+	}
+	return codigo, estrategia, nil
+}
+
+// enviarFormulario hace el POST de un formulario de eldni.com y devuelve el
+// cuerpo; reloj es el que cuenta el Retry-After si el sitio pide esperar
+func enviarFormulario(ctx context.Context, client *http.Client, targetURL string, reloj Reloj, data url.Values) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", targetURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Referer", targetURL)
+	SetHeaders(req)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := errorDeEstado(resp, reloj); err != nil {
+		return nil, err
+	}
+
+	return LeerRespuesta(resp)
+}
+
+// extraerDatosPersona aplica a html las reglas de los nombres
+func extraerDatosPersona(html, dni string, reglas *ReglasFuente) (*Persona, error) {
+	datos, err := reglas.ExtraerCampos(html, dni)
+	if err != nil {
+		return nil, err
+	}
+	if datos.Nombres == "" && datos.ApellidoPaterno == "" && datos.ApellidoMaterno == "" {
+		return nil, fmt.Errorf("%w: no se encontraron datos para el DNI %s", ErrNotFound, dni)
+	}
+	return datos, nil
+}
@@ -0,0 +1,154 @@
+package core
+
+import (
+	"context"
+	"time"
+)
+
+// Enriquecimiento es el resultado de completar una fila con varias fuentes
+type Enriquecimiento struct {
+	Persona     Persona                  // fila con los campos nuevos ya aplicados
+	Nuevos      map[Campo]string         // solo los campos que se completaron
+	Procedencia map[Campo]string         // fuente que aportó cada campo nuevo
+	Confianza   map[Campo]float64        // confianza de cada campo nuevo
+	Conflictos  []Conflicto              // campos que quedan en revisión
+	Consultadas []string                 // fuentes llamadas, en orden
+	Errores     map[string]error         // error de cada fuente que falló
+	Intentos    map[string]int           // intentos hechos con cada fuente consultada
+	Duraciones  map[string]time.Duration // tiempo de cada fuente consultada, con reintentos
+	Pendientes  []Campo                  // campos que quedaron sin completar
+}
+
+// Planificar elige, en orden de preferencia, las fuentes mínimas para cubrir
+// los campos faltantes: una fuente entra solo si aporta algo que las
+// anteriores no cubren. Las fuentes excluidas (p. ej. ya agotadas para ese
+// DNI) se saltan y su lugar lo toma la siguiente que provea esos campos.
+func Planificar(r *Registro, faltantes []Campo, excluidas ...string) []Source {
+	cubiertos := make(map[Campo]bool)
+	var plan []Source
+	for _, f := range r.FuentesPara(faltantes) {
+		if contiene(excluidas, f.Name()) {
+			continue
+		}
+		if ProveeAlguno(f, sinCubrir(faltantes, cubiertos)) {
+			plan = append(plan, f)
+			for _, c := range f.Fields() {
+				cubiertos[c] = true
+			}
+		}
+	}
+	return plan
+}
+
+// Enriquecer consulta las fuentes necesarias para completar p y combina los
+// resultados. Sigue el mismo criterio que Planificar, pero si una fuente
+// falla (tras los reintentos de politica) sus campos quedan pendientes y se
+// prueba la siguiente que los provea. Al final los nombres de todas las
+// fuentes que respondieron, y los que ya tenía p, se contrastan: un campo en
+// el que discrepan no se completa y queda en Conflictos para revisión, igual
+// que los valores que una fuente sacó de un respaldo frágil.
+func Enriquecer(ctx context.Context, r *Registro, p Persona, politica PoliticaReintento) *Enriquecimiento {
+	e := &Enriquecimiento{
+		Persona:     p,
+		Nuevos:      make(map[Campo]string),
+		Procedencia: make(map[Campo]string),
+		Confianza:   make(map[Campo]float64),
+		Errores:     make(map[string]error),
+		Intentos:    make(map[string]int),
+		Duraciones:  make(map[string]time.Duration),
+	}
+
+	evidencias := []Evidencia{evidenciaRegistrada(p)}
+	faltantes := p.Faltantes()
+	for _, f := range r.FuentesPara(faltantes) {
+		pendientes := e.Persona.Faltantes()
+		if !ProveeAlguno(f, pendientes) {
+			continue
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		e.Consultadas = append(e.Consultadas, f.Name())
+		inicio := time.Now()
+		datos, intentos, err := LookupConReintentos(ctx, f, p.DNI, politica)
+		e.Intentos[f.Name()] = intentos
+		e.Duraciones[f.Name()] = time.Since(inicio)
+		if err != nil {
+			e.Errores[f.Name()] = err
+			continue
+		}
+
+		// Lo que salió de un respaldo frágil no se usa: queda en revisión
+		e.Conflictos = append(e.Conflictos, apartarFragiles(datos, f.Name())...)
+		evidencias = append(evidencias, evidenciaDe(f, datos))
+
+		// Solo se confía en los campos que la fuente declara
+		for _, c := range f.Fields() {
+			valor := datos.Valor(c)
+			if valor == "" || e.Persona.Valor(c) != "" {
+				continue
+			}
+			e.Persona.Asignar(c, valor)
+			e.Nuevos[c] = valor
+			e.Procedencia[c] = f.Name()
+			e.Confianza[c] = ConfianzaFuente(f.Name())
+		}
+	}
+
+	confianza, conflictos := ContrastarNombres(p.DNI, evidencias)
+	for c, valor := range confianza {
+		if _, ok := e.Nuevos[c]; ok {
+			e.Confianza[c] = valor
+		}
+	}
+	for _, conflicto := range conflictos {
+		c := conflicto.Campo
+		if _, ok := e.Nuevos[c]; ok {
+			e.Persona.Asignar(c, "")
+			delete(e.Nuevos, c)
+			delete(e.Procedencia, c)
+			delete(e.Confianza, c)
+		}
+	}
+	e.Conflictos = append(e.Conflictos, conflictos...)
+
+	for _, c := range faltantes {
+		if e.Persona.Valor(c) == "" {
+			e.Pendientes = append(e.Pendientes, c)
+		}
+	}
+	return e
+}
+
+// evidenciaDe son los nombres que dio f: los campos que declara o, si no
+// declara nombres pero igual los trae (p. ej. dniperu), el nombre completo
+func evidenciaDe(f Source, datos *Persona) Evidencia {
+	e := Evidencia{Fuente: f.Name(), Persona: Persona{DNI: datos.DNI, Extraccion: datos.Extraccion}}
+	for _, c := range f.Fields() {
+		e.Persona.Asignar(c, datos.Valor(c))
+	}
+	if !ProveeAlguno(f, []Campo{CampoNombres}) {
+		e.NombreCompleto = datos.Nombres
+	}
+	return e
+}
+
+func contiene(lista []string, s string) bool {
+	for _, x := range lista {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+func sinCubrir(campos []Campo, cubiertos map[Campo]bool) []Campo {
+	var resto []Campo
+	for _, c := range campos {
+		if !cubiertos[c] {
+			resto = append(resto, c)
+		}
+	}
+	return resto
+}
@@ -0,0 +1,79 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Errores que devuelven las fuentes. Se comparan con errors.Is; los mensajes
+// concretos los envuelven con el detalle de cada caso.
+var (
+	ErrRateLimited  = errors.New("demasiadas solicitudes")
+	ErrNotFound     = errors.New("DNI no encontrado")
+	ErrAccessDenied = errors.New("acceso denegado")
+	ErrTokenMissing = errors.New("token no encontrado")
+	ErrParse        = errors.New("respuesta ilegible")
+	ErrInvalidDNI   = errors.New("DNI inválido: debe tener 8 dígitos")
+)
+
+// RateLimitError es un ErrRateLimited con la espera que pidió el servidor
+// (Retry-After), o cero si no la indicó
+type RateLimitError struct {
+	Status     int
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	msg := ErrRateLimited.Error()
+	if e.Status != 0 {
+		msg = fmt.Sprintf("%s (HTTP %d)", msg, e.Status)
+	}
+	if e.RetryAfter > 0 {
+		msg = fmt.Sprintf("%s, reintentar en %v", msg, e.RetryAfter)
+	}
+	return msg
+}
+
+func (e *RateLimitError) Is(target error) bool { return target == ErrRateLimited }
+
+// ErrorHTTP es una respuesta con estado de error que no tiene un significado
+// más específico
+type ErrorHTTP struct {
+	Status int
+}
+
+func (e *ErrorHTTP) Error() string { return fmt.Sprintf("error del servidor: %d", e.Status) }
+
+// errorDeEstado traduce el estado HTTP de resp a un error tipado, o nil si
+// la respuesta fue exitosa. Un Retry-After con fecha se cuenta desde la hora
+// de r.
+func errorDeEstado(resp *http.Response, r Reloj) error {
+	switch {
+	case resp.StatusCode < 400:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode == http.StatusServiceUnavailable:
+		return &RateLimitError{Status: resp.StatusCode, RetryAfter: leerRetryAfter(resp.Header.Get("Retry-After"), r.Ahora())}
+	case resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("%w (HTTP %d)", ErrAccessDenied, resp.StatusCode)
+	}
+	return &ErrorHTTP{Status: resp.StatusCode}
+}
+
+// leerRetryAfter acepta segundos o una fecha HTTP, que se cuenta desde ahora
+func leerRetryAfter(valor string, ahora time.Time) time.Duration {
+	if valor == "" {
+		return 0
+	}
+	if segundos, err := strconv.Atoi(valor); err == nil && segundos > 0 {
+		return time.Duration(segundos) * time.Second
+	}
+	if fecha, err := http.ParseTime(valor); err == nil {
+		if espera := fecha.Sub(ahora); espera > 0 {
+			return espera
+		}
+	}
+	return 0
+}
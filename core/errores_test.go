@@ -0,0 +1,103 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestEsReintentable(t *testing.T) {
+	casos := []struct {
+		err          error
+		reintentable bool
+	}{
+		{nil, false},
+		{context.Canceled, false},
+		{fmt.Errorf("consulta: %w", context.Canceled), false},
+		{ErrNotFound, false},
+		{fmt.Errorf("%w: sin datos", ErrNotFound), false},
+		{ErrInvalidDNI, false},
+		{ErrAccessDenied, false},
+		{fmt.Errorf("%w (HTTP 403)", ErrAccessDenied), false},
+		{ErrParse, false},
+		{ErrRateLimited, true},
+		{&RateLimitError{Status: http.StatusTooManyRequests, RetryAfter: time.Minute}, true},
+		{fmt.Errorf("lote: %w", &RateLimitError{Status: http.StatusServiceUnavailable}), true},
+		{ErrTokenMissing, true},
+		{&ErrorHTTP{Status: 500}, true},
+		{&ErrorHTTP{Status: 502}, true},
+		{&ErrorHTTP{Status: 419}, true}, // token CSRF vencido
+		{&ErrorHTTP{Status: 404}, false},
+		{&ErrorHTTP{Status: 400}, false},
+		{context.DeadlineExceeded, true},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+	}
+	for _, c := range casos {
+		if EsReintentable(c.err) != c.reintentable {
+			t.Errorf("EsReintentable(%v) = %v", c.err, !c.reintentable)
+		}
+	}
+}
+
+func TestRateLimitError(t *testing.T) {
+	casos := []struct {
+		err     *RateLimitError
+		mensaje string
+	}{
+		{&RateLimitError{}, "demasiadas solicitudes"},
+		{&RateLimitError{Status: 429}, "demasiadas solicitudes (HTTP 429)"},
+		{&RateLimitError{Status: 503, RetryAfter: 30 * time.Second}, "demasiadas solicitudes (HTTP 503), reintentar en 30s"},
+	}
+	for _, c := range casos {
+		if c.err.Error() != c.mensaje {
+			t.Errorf("%q, se esperaba %q", c.err.Error(), c.mensaje)
+		}
+		if !errors.Is(c.err, ErrRateLimited) || errors.Is(c.err, ErrNotFound) {
+			t.Errorf("%v no se compara como ErrRateLimited", c.err)
+		}
+	}
+}
+
+func TestErrorDeEstado(t *testing.T) {
+	r := &relojFijo{ahora: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	casos := []struct {
+		status     int
+		retryAfter string
+		esperado   error
+		espera     time.Duration
+	}{
+		{http.StatusOK, "", nil, 0},
+		{http.StatusFound, "", nil, 0},
+		{http.StatusTooManyRequests, "", ErrRateLimited, 0},
+		{http.StatusTooManyRequests, "120", ErrRateLimited, 2 * time.Minute},
+		{http.StatusServiceUnavailable, r.ahora.Add(time.Minute).Format(http.TimeFormat), ErrRateLimited, time.Minute},
+		{http.StatusTooManyRequests, r.ahora.Add(-time.Minute).Format(http.TimeFormat), ErrRateLimited, 0},
+		{http.StatusTooManyRequests, "pronto", ErrRateLimited, 0},
+		{http.StatusTooManyRequests, "-5", ErrRateLimited, 0},
+		{http.StatusForbidden, "", ErrAccessDenied, 0},
+	}
+	for _, c := range casos {
+		resp := &http.Response{StatusCode: c.status, Header: http.Header{}}
+		if c.retryAfter != "" {
+			resp.Header.Set("Retry-After", c.retryAfter)
+		}
+		err := errorDeEstado(resp, r)
+		if !errors.Is(err, c.esperado) || (c.esperado == nil) != (err == nil) {
+			t.Errorf("%d: %v, se esperaba %v", c.status, err, c.esperado)
+			continue
+		}
+		var limite *RateLimitError
+		if errors.As(err, &limite) && limite.RetryAfter != c.espera {
+			t.Errorf("%d con Retry-After %q: espera %v, se esperaba %v", c.status, c.retryAfter, limite.RetryAfter, c.espera)
+		}
+	}
+
+	var errHTTP *ErrorHTTP
+	if err := errorDeEstado(&http.Response{StatusCode: 500}, r); !errors.As(err, &errHTTP) || errHTTP.Status != 500 {
+		t.Errorf("500: %v", err)
+	}
+}
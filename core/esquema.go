@@ -0,0 +1,246 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Las migraciones son pares NNNN_nombre.up.sql / NNNN_nombre.down.sql que
+// se aplican en orden de versión, cada una en su transacción
+//
+//go:embed migraciones/*.sql
+var archivosMigraciones embed.FS
+
+// bloqueoMigraciones es la clave (arbitraria, la misma en todos los
+// programas) del advisory lock que impide que dos procesos migren a la vez
+const bloqueoMigraciones = 720001
+
+// Migracion es un cambio de esquema junto con el SQL que lo revierte
+type Migracion struct {
+	Version int
+	Nombre  string
+	Subir   string
+	Bajar   string
+}
+
+// EstadoMigracion es una migración y, si está aplicada, cuándo se aplicó
+type EstadoMigracion struct {
+	Migracion
+	Aplicada   bool
+	AplicadaEn time.Time
+}
+
+// Migraciones devuelve las migraciones embebidas ordenadas por versión
+func Migraciones() ([]Migracion, error) {
+	archivos, err := archivosMigraciones.ReadDir("migraciones")
+	if err != nil {
+		return nil, err
+	}
+
+	porVersion := make(map[int]*Migracion)
+	for _, a := range archivos {
+		base, direccion, ok := strings.Cut(strings.TrimSuffix(a.Name(), ".sql"), ".")
+		numero, nombre, ok2 := strings.Cut(base, "_")
+		version, err := strconv.Atoi(numero)
+		if !ok || !ok2 || err != nil || (direccion != "up" && direccion != "down") {
+			return nil, fmt.Errorf("nombre de migración inválido %q", a.Name())
+		}
+		contenido, err := archivosMigraciones.ReadFile(path.Join("migraciones", a.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := porVersion[version]
+		if !ok {
+			m = &Migracion{Version: version, Nombre: nombre}
+			porVersion[version] = m
+		} else if m.Nombre != nombre {
+			return nil, fmt.Errorf("versión %d repetida: %s y %s", version, m.Nombre, nombre)
+		}
+		if direccion == "up" {
+			m.Subir = string(contenido)
+		} else {
+			m.Bajar = string(contenido)
+		}
+	}
+
+	migraciones := make([]Migracion, 0, len(porVersion))
+	for _, m := range porVersion {
+		if m.Subir == "" || m.Bajar == "" {
+			return nil, fmt.Errorf("a la migración %04d_%s le falta el .up.sql o el .down.sql", m.Version, m.Nombre)
+		}
+		migraciones = append(migraciones, *m)
+	}
+	sort.Slice(migraciones, func(i, j int) bool { return migraciones[i].Version < migraciones[j].Version })
+	return migraciones, nil
+}
+
+// EstadoMigraciones lista todas las migraciones indicando cuáles están aplicadas
+func EstadoMigraciones(ctx context.Context, db *sql.DB) ([]EstadoMigracion, error) {
+	if err := crearTablaMigraciones(ctx, db); err != nil {
+		return nil, err
+	}
+	return estadoMigraciones(ctx, db)
+}
+
+// MigrarArriba aplica las migraciones pendientes y devuelve las aplicadas
+func MigrarArriba(ctx context.Context, db *sql.DB) ([]Migracion, error) {
+	var aplicadas []Migracion
+	err := conBloqueoMigraciones(ctx, db, func(conn *sql.Conn) error {
+		estado, err := estadoMigraciones(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, e := range estado {
+			if e.Aplicada {
+				continue
+			}
+			err := ejecutarMigracion(ctx, conn, e.Migracion, e.Subir,
+				`INSERT INTO schema_migrations (version, nombre) VALUES ($1, $2)`)
+			if err != nil {
+				return err
+			}
+			aplicadas = append(aplicadas, e.Migracion)
+		}
+		return nil
+	})
+	return aplicadas, err
+}
+
+// MigrarAbajo revierte las últimas pasos migraciones aplicadas y devuelve
+// las revertidas
+func MigrarAbajo(ctx context.Context, db *sql.DB, pasos int) ([]Migracion, error) {
+	var revertidas []Migracion
+	err := conBloqueoMigraciones(ctx, db, func(conn *sql.Conn) error {
+		estado, err := estadoMigraciones(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(estado) - 1; i >= 0 && len(revertidas) < pasos; i-- {
+			e := estado[i]
+			if !e.Aplicada {
+				continue
+			}
+			err := ejecutarMigracion(ctx, conn, e.Migracion, e.Bajar,
+				`DELETE FROM schema_migrations WHERE version = $1 AND nombre = $2`)
+			if err != nil {
+				return err
+			}
+			revertidas = append(revertidas, e.Migracion)
+		}
+		return nil
+	})
+	return revertidas, err
+}
+
+// ComprobarEsquema falla si hay migraciones sin aplicar, para que los
+// programas no corran contra un esquema viejo
+func ComprobarEsquema(ctx context.Context, db *sql.DB) error {
+	estado, err := EstadoMigraciones(ctx, db)
+	if err != nil {
+		return err
+	}
+	var pendientes []string
+	for _, e := range estado {
+		if !e.Aplicada {
+			pendientes = append(pendientes, fmt.Sprintf("%04d_%s", e.Version, e.Nombre))
+		}
+	}
+	if len(pendientes) > 0 {
+		return fmt.Errorf("migraciones pendientes: %s (aplicarlas con `enrich migrate up`)", strings.Join(pendientes, ", "))
+	}
+	return nil
+}
+
+type consultor interface {
+	ejecutor
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func crearTablaMigraciones(ctx context.Context, ex ejecutor) error {
+	_, err := ex.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version     INTEGER     PRIMARY KEY,
+			nombre      TEXT        NOT NULL,
+			aplicada_en TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`)
+	return err
+}
+
+func estadoMigraciones(ctx context.Context, db consultor) ([]EstadoMigracion, error) {
+	migraciones, err := Migraciones()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT version, aplicada_en FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	aplicadas := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var en time.Time
+		if err := rows.Scan(&version, &en); err != nil {
+			return nil, err
+		}
+		aplicadas[version] = en
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	estado := make([]EstadoMigracion, len(migraciones))
+	for i, m := range migraciones {
+		en, ok := aplicadas[m.Version]
+		estado[i] = EstadoMigracion{Migracion: m, Aplicada: ok, AplicadaEn: en}
+	}
+	return estado, nil
+}
+
+// conBloqueoMigraciones corre fn en una conexión que tiene el advisory lock
+// de migraciones
+func conBloqueoMigraciones(ctx context.Context, db *sql.DB, fn func(conn *sql.Conn) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, bloqueoMigraciones); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, bloqueoMigraciones)
+
+	if err := crearTablaMigraciones(ctx, conn); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// ejecutarMigracion corre ddl y registra el cambio con registro, en una
+// sola transacción
+func ejecutarMigracion(ctx context.Context, conn *sql.Conn, m Migracion, ddl, registro string) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, ddl); err != nil {
+		return fmt.Errorf("migración %04d_%s: %v", m.Version, m.Nombre, err)
+	}
+	if _, err := tx.ExecContext(ctx, registro, m.Version, m.Nombre); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,65 @@
+package core
+
+import (
+	"log/slog"
+)
+
+// Estrategias con las que los parsers encuentran cada campo, de la más
+// estable a la más frágil
+const (
+	EstrategiaJSON  = "json"      // campo de una respuesta JSON
+	EstrategiaInput = "input"     // value de un input con id conocido (#nombres, #digito_verificador)
+	EstrategiaMark  = "mark"      // texto del <mark> que resalta el resultado
+	EstrategiaTabla = "tabla"     // posición de la celda en la tabla de resultados
+	EstrategiaClase = "clase_css" // clases o atributos data-* habituales
+	EstrategiaRegex = "regex"     // expresión regular sobre el HTML crudo
+)
+
+// MotivoRespaldo es el motivo de revisión de un valor que salió de una
+// estrategia frágil: suele indicar que el sitio cambió su HTML
+const MotivoRespaldo = "respaldo_regex"
+
+// EsFragil indica si un valor obtenido con estrategia no debe escribirse
+// sin que alguien lo revise
+func EsFragil(estrategia string) bool {
+	return estrategia == EstrategiaRegex
+}
+
+// registrarExtraccion cuenta con qué estrategia salió cada campo de p y
+// avisa de los que salieron de un respaldo frágil
+func registrarExtraccion(fuente string, p *Persona) {
+	for c, estrategia := range p.Extraccion {
+		metricaExtracciones.WithLabelValues(fuente, string(c), estrategia).Inc()
+		if EsFragil(estrategia) {
+			slog.Warn("campo extraído con un respaldo frágil, queda en revisión",
+				AtributoDNI(p.DNI), "source", fuente, "field", string(c), "strategy", estrategia)
+		}
+	}
+}
+
+// apartarFragiles quita de p los campos que salieron de una estrategia
+// frágil y los devuelve como conflictos para revisión
+func apartarFragiles(p *Persona, fuente string) []Conflicto {
+	var apartados []Conflicto
+	for _, c := range Campos {
+		estrategia := p.Extraccion[c]
+		valor := p.Valor(c)
+		if valor == "" || !EsFragil(estrategia) {
+			continue
+		}
+		if c == CampoFechaNacimiento {
+			// Los candidatos van en el formato del historial
+			if fecha, err := ConvertirFecha(valor); err == nil {
+				valor = fecha
+			}
+		}
+		apartados = append(apartados, Conflicto{
+			DNI:        p.DNI,
+			Campo:      c,
+			Motivo:     MotivoRespaldo,
+			Candidatos: []Candidato{{Fuente: fuente, Valor: valor, Metodo: estrategia}},
+		})
+		p.Asignar(c, "")
+	}
+	return apartados
+}
@@ -0,0 +1,153 @@
+package core
+
+import (
+	"context"
+	"fmt"
+)
+
+// Campo identifica una columna de personas que una fuente puede completar
+type Campo string
+
+const (
+	CampoNombres         Campo = "nombres"
+	CampoApellidoPaterno Campo = "apellido_paterno"
+	CampoApellidoMaterno Campo = "apellido_materno"
+	CampoFechaNacimiento Campo = "fecha_nacimiento"
+	CampoCodigoVerif     Campo = "codigo_verificador"
+)
+
+// Campos lista todas las columnas enriquecibles en el orden de la tabla
+var Campos = []Campo{
+	CampoNombres,
+	CampoApellidoPaterno,
+	CampoApellidoMaterno,
+	CampoFechaNacimiento,
+	CampoCodigoVerif,
+}
+
+// Valor devuelve el contenido de un campo de la persona
+func (p *Persona) Valor(c Campo) string {
+	switch c {
+	case CampoNombres:
+		return p.Nombres
+	case CampoApellidoPaterno:
+		return p.ApellidoPaterno
+	case CampoApellidoMaterno:
+		return p.ApellidoMaterno
+	case CampoFechaNacimiento:
+		return p.FechaNacimiento
+	case CampoCodigoVerif:
+		return p.CodigoVerif
+	}
+	return ""
+}
+
+// Asignar cambia el contenido de un campo de la persona
+func (p *Persona) Asignar(c Campo, valor string) {
+	switch c {
+	case CampoNombres:
+		p.Nombres = valor
+	case CampoApellidoPaterno:
+		p.ApellidoPaterno = valor
+	case CampoApellidoMaterno:
+		p.ApellidoMaterno = valor
+	case CampoFechaNacimiento:
+		p.FechaNacimiento = valor
+	case CampoCodigoVerif:
+		p.CodigoVerif = valor
+	}
+}
+
+// Faltantes devuelve los campos que la persona todavía no tiene
+func (p *Persona) Faltantes() []Campo {
+	var faltan []Campo
+	for _, c := range Campos {
+		if p.Valor(c) == "" {
+			faltan = append(faltan, c)
+		}
+	}
+	return faltan
+}
+
+// Source es un proveedor de datos de personas. Lookup devuelve una Persona
+// parcial: solo los campos listados en Fields son confiables, el resto puede
+// venir vacío o con información que no corresponde a esa columna.
+type Source interface {
+	Name() string
+	Fields() []Campo
+	Lookup(ctx context.Context, dni string) (*Persona, error)
+}
+
+// Registro mantiene las fuentes disponibles en orden de preferencia
+type Registro struct {
+	fuentes []Source
+}
+
+func NuevoRegistro(fuentes ...Source) *Registro {
+	r := &Registro{}
+	for _, f := range fuentes {
+		r.Registrar(f)
+	}
+	return r
+}
+
+// Registrar agrega una fuente al final; un nombre repetido es un error de programación
+func (r *Registro) Registrar(s Source) {
+	if r.Buscar(s.Name()) != nil {
+		panic(fmt.Sprintf("fuente %q registrada dos veces", s.Name()))
+	}
+	r.fuentes = append(r.fuentes, s)
+}
+
+func (r *Registro) Fuentes() []Source {
+	return r.fuentes
+}
+
+// Buscar devuelve la fuente con ese nombre o nil
+func (r *Registro) Buscar(nombre string) Source {
+	for _, f := range r.fuentes {
+		if f.Name() == nombre {
+			return f
+		}
+	}
+	return nil
+}
+
+// Subconjunto devuelve un registro solo con las fuentes nombradas, en el
+// mismo orden de preferencia
+func (r *Registro) Subconjunto(nombres []string) *Registro {
+	sub := &Registro{}
+	for _, f := range r.fuentes {
+		for _, n := range nombres {
+			if f.Name() == n {
+				sub.fuentes = append(sub.fuentes, f)
+				break
+			}
+		}
+	}
+	return sub
+}
+
+// FuentesPara devuelve, en orden de preferencia, las fuentes que pueden
+// completar al menos uno de los campos faltantes
+func (r *Registro) FuentesPara(faltantes []Campo) []Source {
+	var utiles []Source
+	for _, f := range r.fuentes {
+		if ProveeAlguno(f, faltantes) {
+			utiles = append(utiles, f)
+		}
+	}
+	return utiles
+}
+
+// ProveeAlguno indica si la fuente declara alguno de los campos
+func ProveeAlguno(s Source, campos []Campo) bool {
+	for _, provisto := range s.Fields() {
+		for _, c := range campos {
+			if provisto == c {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package grabacion
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"personas/core"
+)
+
+// ArchivoCasos lista, dentro del directorio de grabaciones, qué se espera de
+// cada consulta grabada
+const ArchivoCasos = "casos.json"
+
+// Caso es una consulta grabada y lo que la fuente debe devolver al
+// reproducirla: los campos esperados o el resultado (found, not_found, ...)
+type Caso struct {
+	Fuente    string            `json:"fuente"`
+	DNI       string            `json:"dni"`
+	Resultado string            `json:"resultado"`
+	Esperado  map[string]string `json:"esperado,omitempty"`
+	Nota      string            `json:"nota,omitempty"`
+}
+
+// LeerCasos lee dir/casos.json; sin archivo, no hay casos
+func LeerCasos(dir string) ([]Caso, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ArchivoCasos))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var casos []Caso
+	if err := json.Unmarshal(data, &casos); err != nil {
+		return nil, err
+	}
+	return casos, nil
+}
+
+// GuardarCaso agrega c a los casos, reemplazando el que tenga la misma
+// fuente y DNI
+func GuardarCaso(dir string, c Caso) error {
+	casos, err := LeerCasos(dir)
+	if err != nil {
+		return err
+	}
+
+	reemplazado := false
+	for i := range casos {
+		if casos[i].Fuente == c.Fuente && casos[i].DNI == c.DNI {
+			casos[i] = c
+			reemplazado = true
+		}
+	}
+	if !reemplazado {
+		casos = append(casos, c)
+	}
+
+	data, err := json.MarshalIndent(casos, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, ArchivoCasos), append(data, '\n'), 0o644)
+}
+
+// NuevaFuente crea la fuente nombre con una sesión nueva que sale por rt
+func NuevaFuente(nombre string, rt http.RoundTripper, opts ...core.Opcion) (core.Source, error) {
+	opts = append(opts, core.ConTransporte(rt))
+	switch nombre {
+	case core.NombreDigitoLocal:
+		return core.FuenteDigitoLocal{}, nil
+	case core.NombreEldniDatos:
+		return core.NuevaFuenteEldniDatos(opts...), nil
+	case core.NombreEldniDigito:
+		return core.NuevaFuenteEldniDigito(opts...), nil
+	case core.NombreDniperu:
+		return core.NewDNIScraper(opts...), nil
+	}
+	return nil, fmt.Errorf("fuente desconocida %q", nombre)
+}
+
+// Reproducir consulta c con las respuestas grabadas en dir y devuelve en
+// qué difiere de lo esperado; vacío si coincide. Las grabaciones están
+// hechas contra los sitios reales, así que opts no debería cambiar las URLs.
+func Reproducir(ctx context.Context, dir string, c Caso, opts ...core.Opcion) string {
+	tr := NuevoTransporte(dir, ModoReproducir)
+	fuente, err := NuevaFuente(c.Fuente, tr, opts...)
+	if err != nil {
+		return err.Error()
+	}
+
+	persona, err := fuente.Lookup(ctx, c.DNI)
+	if resultado := core.ClasificarResultado(err); resultado != c.Resultado {
+		return fmt.Sprintf("resultado %s, se esperaba %s (%v)", resultado, c.Resultado, err)
+	}
+	if err != nil {
+		return ""
+	}
+
+	var diferencias []string
+	for campo, esperado := range c.Esperado {
+		if obtenido := persona.Valor(core.Campo(campo)); obtenido != esperado {
+			diferencias = append(diferencias, fmt.Sprintf("%s=%q, se esperaba %q", campo, obtenido, esperado))
+		}
+	}
+	return strings.Join(diferencias, "; ")
+}
+
+// DNIFicticio devuelve el primer DNI de la forma 000000NN que no usa
+// ningún caso de dir, para reemplazar al real al grabar
+func DNIFicticio(dir string) (string, error) {
+	casos, err := LeerCasos(dir)
+	if err != nil {
+		return "", err
+	}
+	usados := make(map[string]bool)
+	for _, c := range casos {
+		usados[c.DNI] = true
+	}
+	for n := 1; n < 100; n++ {
+		if dni := fmt.Sprintf("%08d", n); !usados[dni] {
+			return dni, nil
+		}
+	}
+	return "", fmt.Errorf("no quedan DNIs ficticios libres en %s", dir)
+}
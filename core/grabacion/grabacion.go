@@ -0,0 +1,335 @@
+// Package grabacion graba respuestas HTTP reales en archivos JSON y las
+// reproduce después, para ejercitar los parsers de las fuentes sin red.
+//
+// Al grabar, las respuestas quedan en memoria hasta que se sabe qué datos
+// personales trajeron; recién entonces el Limpiador reemplaza el DNI, cada
+// palabra de los nombres y todas las fechas por valores ficticios, tanto en
+// la petición como en la respuesta, y se escriben los archivos. Como la
+// clave de cada archivo se calcula sobre la petición ya limpia, al
+// reproducir se consulta directamente con los valores ficticios.
+package grabacion
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Modos del Transporte
+const (
+	ModoReproducir = "reproducir" // responde solo desde los archivos
+	ModoGrabar     = "grabar"     // consulta de verdad y guarda la respuesta
+)
+
+// ErrSinGrabacion indica que no hay archivo para la petición al reproducir
+var ErrSinGrabacion = errors.New("no hay grabación para la petición")
+
+// cabecerasGuardadas son las únicas cabeceras de respuesta que se conservan;
+// las cookies y demás quedan fuera de los archivos
+var cabecerasGuardadas = []string{"Content-Type", "Retry-After", "Location"}
+
+// Grabacion es el contenido de cada archivo
+type Grabacion struct {
+	Metodo    string    `json:"metodo"`
+	URL       string    `json:"url"`
+	Cuerpo    string    `json:"cuerpo,omitempty"`
+	Respuesta Respuesta `json:"respuesta"`
+}
+
+type Respuesta struct {
+	Estado    int               `json:"estado"`
+	Cabeceras map[string]string `json:"cabeceras,omitempty"`
+	Cuerpo    string            `json:"cuerpo"`
+}
+
+// Fechas con las que se reemplazan todas las de las respuestas grabadas
+const (
+	FechaFicticia    = "01/01/1990"
+	FechaFicticiaISO = "1990-01-01"
+)
+
+// palabrasFicticias reemplazan, en orden, las palabras de los nombres reales
+var palabrasFicticias = []string{
+	"JUAN", "CARLOS", "PEREZ", "GOMEZ", "MARIA", "ELENA", "QUISPE", "MAMANI",
+	"ROSA", "LUIS", "ROJAS", "TORRES", "ANA", "LUCIA", "VEGA", "FLORES",
+}
+
+var (
+	rePalabra  = regexp.MustCompile(`\p{L}+`)
+	reFecha    = regexp.MustCompile(`\b\d{2}/\d{2}/\d{4}\b`)
+	reFechaISO = regexp.MustCompile(`\b\d{4}-\d{2}-\d{2}\b`)
+)
+
+// Limpiador reemplaza datos reales por ficticios. Los reemplazos exactos se
+// aplican de la cadena más larga a la más corta para que un nombre completo
+// se reemplace antes que sus partes; después se reemplazan las palabras de
+// los nombres, en mayúsculas o con inicial mayúscula, y las fechas.
+type Limpiador struct {
+	reales       []string
+	falsos       map[string]string
+	reemplazador *strings.Replacer
+	palabras     map[string]string // en mayúsculas
+	fechas       bool
+}
+
+// NuevoLimpiadorAutomatico oculta el dni consultado con dniFicticio, cada
+// palabra de tres o más letras de nombres y todas las fechas; reemplazos
+// (real=ficticio) se aplican antes, para lo que esto no cubra
+func NuevoLimpiadorAutomatico(dni, dniFicticio string, nombres []string, reemplazos map[string]string) *Limpiador {
+	exactos := map[string]string{dni: dniFicticio}
+	for real, falso := range reemplazos {
+		exactos[real] = falso
+	}
+	l := NuevoLimpiador(exactos)
+	l.fechas = true
+
+	reales := make(map[string]bool)
+	var orden []string
+	for _, n := range nombres {
+		for _, p := range rePalabra.FindAllString(strings.ToUpper(n), -1) {
+			if len([]rune(p)) >= 3 && !reales[p] {
+				reales[p] = true
+				orden = append(orden, p)
+			}
+		}
+	}
+	// Una ficticia que coincide con una real no la ocultaría
+	var libres []string
+	for _, f := range palabrasFicticias {
+		if !reales[f] {
+			libres = append(libres, f)
+		}
+	}
+	l.palabras = make(map[string]string)
+	for i, p := range orden {
+		l.palabras[p] = libres[i%len(libres)]
+	}
+	return l
+}
+
+// NuevoLimpiador reemplaza solo las cadenas de reemplazos (real=ficticio)
+func NuevoLimpiador(reemplazos map[string]string) *Limpiador {
+	l := &Limpiador{falsos: make(map[string]string)}
+	for real, falso := range reemplazos {
+		if real == "" {
+			continue
+		}
+		l.reales = append(l.reales, real)
+		l.falsos[real] = falso
+	}
+	sort.Slice(l.reales, func(i, j int) bool { return len(l.reales[i]) > len(l.reales[j]) })
+
+	var pares []string
+	for _, real := range l.reales {
+		// También en su forma de formulario (espacios como +)
+		pares = append(pares, real, l.falsos[real])
+		if cod := strings.ReplaceAll(real, " ", "+"); cod != real {
+			pares = append(pares, cod, strings.ReplaceAll(l.falsos[real], " ", "+"))
+		}
+	}
+	l.reemplazador = strings.NewReplacer(pares...)
+	return l
+}
+
+// Limpiar aplica los reemplazos a s; un Limpiador nil no cambia nada
+func (l *Limpiador) Limpiar(s string) string {
+	if l == nil {
+		return s
+	}
+	if len(l.reales) > 0 {
+		s = l.reemplazador.Replace(s)
+	}
+	if len(l.palabras) > 0 {
+		s = rePalabra.ReplaceAllStringFunc(s, func(p string) string {
+			mayus := strings.ToUpper(p)
+			falso, ok := l.palabras[mayus]
+			switch {
+			case !ok:
+				return p
+			case p == mayus:
+				return falso
+			case p == titulo(mayus):
+				return titulo(falso)
+			}
+			// En minúsculas suele ser HTML o CSS, no un nombre
+			return p
+		})
+	}
+	if l.fechas {
+		s = reFecha.ReplaceAllString(s, FechaFicticia)
+		s = reFechaISO.ReplaceAllString(s, FechaFicticiaISO)
+	}
+	return s
+}
+
+// titulo pasa una palabra en mayúsculas a inicial mayúscula
+func titulo(p string) string {
+	r := []rune(p)
+	return string(r[:1]) + strings.ToLower(string(r[1:]))
+}
+
+// Transporte es un http.RoundTripper que graba o reproduce según Modo. Al
+// grabar, las respuestas quedan pendientes hasta llamar a Guardar.
+type Transporte struct {
+	Dir  string
+	Modo string
+	Base http.RoundTripper // transporte real al grabar; nil usa http.DefaultTransport
+
+	mu         sync.Mutex
+	pendientes []Grabacion
+}
+
+func NuevoTransporte(dir, modo string) *Transporte {
+	return &Transporte{Dir: dir, Modo: modo}
+}
+
+func (t *Transporte) RoundTrip(req *http.Request) (*http.Response, error) {
+	var cuerpo []byte
+	if req.Body != nil {
+		var err error
+		if cuerpo, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(cuerpo))
+	}
+
+	g := Grabacion{Metodo: req.Method, URL: req.URL.String(), Cuerpo: string(cuerpo)}
+	if t.Modo == ModoGrabar {
+		if err := t.grabar(req, &g); err != nil {
+			return nil, err
+		}
+	} else if err := leer(filepath.Join(t.Dir, nombreArchivo(g)), &g); err != nil {
+		return nil, err
+	}
+
+	return construirRespuesta(req, g.Respuesta), nil
+}
+
+// grabar hace la petición real y deja la respuesta, sin limpiar, pendiente
+func (t *Transporte) grabar(req *http.Request, g *Grabacion) error {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var lector io.Reader = resp.Body
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		lector = gz
+	}
+	cuerpo, err := io.ReadAll(lector)
+	if err != nil {
+		return err
+	}
+
+	g.Respuesta = Respuesta{
+		Estado:    resp.StatusCode,
+		Cabeceras: make(map[string]string),
+		Cuerpo:    string(cuerpo),
+	}
+	for _, c := range cabecerasGuardadas {
+		if v := resp.Header.Get(c); v != "" {
+			g.Respuesta.Cabeceras[c] = v
+		}
+	}
+
+	t.mu.Lock()
+	t.pendientes = append(t.pendientes, *g)
+	t.mu.Unlock()
+	return nil
+}
+
+// Guardar limpia con l las respuestas grabadas y las escribe en Dir
+func (t *Transporte) Guardar(l *Limpiador) error {
+	t.mu.Lock()
+	pendientes := t.pendientes
+	t.pendientes = nil
+	t.mu.Unlock()
+
+	if err := os.MkdirAll(t.Dir, 0o755); err != nil {
+		return err
+	}
+	for _, g := range pendientes {
+		g.URL, g.Cuerpo = l.Limpiar(g.URL), l.Limpiar(g.Cuerpo)
+		g.Respuesta.Cuerpo = l.Limpiar(g.Respuesta.Cuerpo)
+		for c, v := range g.Respuesta.Cabeceras {
+			g.Respuesta.Cabeceras[c] = l.Limpiar(v)
+		}
+
+		data, err := json.MarshalIndent(g, "", "  ")
+		if err != nil {
+			return err
+		}
+		ruta := filepath.Join(t.Dir, nombreArchivo(g))
+		slog.Info("respuesta grabada", "metodo", g.Metodo, "url", g.URL, "archivo", ruta)
+		if err := os.WriteFile(ruta, append(data, '\n'), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func leer(ruta string, g *Grabacion) error {
+	data, err := os.ReadFile(ruta)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %s %s (%s)", ErrSinGrabacion, g.Metodo, g.URL, ruta)
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, g)
+}
+
+func construirRespuesta(req *http.Request, r Respuesta) *http.Response {
+	header := make(http.Header)
+	for k, v := range r.Cabeceras {
+		header.Set(k, v)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.Estado, http.StatusText(r.Estado)),
+		StatusCode:    r.Estado,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(r.Cuerpo)),
+		ContentLength: int64(len(r.Cuerpo)),
+		Request:       req,
+	}
+}
+
+var reNoAlfanumerico = regexp.MustCompile(`[^a-zA-Z0-9]+`)
+
+// nombreArchivo arma un nombre legible (host y ruta) seguido de un hash de
+// la petición limpia, que es lo que distingue un archivo de otro
+func nombreArchivo(g Grabacion) string {
+	h := sha256.Sum256([]byte(g.Metodo + " " + g.URL + "\n" + g.Cuerpo))
+	legible := strings.Trim(reNoAlfanumerico.ReplaceAllString(strings.TrimPrefix(strings.TrimPrefix(g.URL, "https://"), "http://"), "_"), "_")
+	if len(legible) > 60 {
+		legible = legible[:60]
+	}
+	return fmt.Sprintf("%s_%s_%s.json", strings.ToLower(g.Metodo), legible, hex.EncodeToString(h[:])[:12])
+}
@@ -0,0 +1,55 @@
+package grabacion_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"personas/core"
+	"personas/core/grabacion"
+)
+
+const dirGrabaciones = "../testdata/grabaciones"
+
+// TestReproducir reproduce cada caso grabado contra su fuente: si un parser
+// deja de entender una respuesta real, falla acá
+func TestReproducir(t *testing.T) {
+	casos, err := grabacion.LeerCasos(dirGrabaciones)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(casos) == 0 {
+		t.Fatalf("no hay casos en %s", dirGrabaciones)
+	}
+
+	cfg := core.ConfigDefecto()
+	for _, c := range casos {
+		t.Run(c.Fuente+"/"+c.DNI, func(t *testing.T) {
+			if problema := grabacion.Reproducir(context.Background(), dirGrabaciones, c,
+				core.ConURLs(cfg.URLs), core.ConTimeout(cfg.Timeout)); problema != "" {
+				t.Error(problema)
+			}
+		})
+	}
+}
+
+func TestLimpiadorAutomatico(t *testing.T) {
+	l := grabacion.NuevoLimpiadorAutomatico("45678912", "00000009",
+		[]string{"José Luis", "DE LA CRUZ", "PEÑA"}, map[string]string{"Lima": "Ciudad"})
+
+	cuerpo := `<td>45678912</td><td>JOSÉ LUIS</td><td>DE LA CRUZ PEÑA</td><p>José Luis, nacido el 15/03/1980 (1980-03-15) en Lima</p><div class="cruz">`
+	limpio := l.Limpiar(cuerpo)
+	for _, real := range []string{"45678912", "JOSÉ", "José", "LUIS", "Luis", "CRUZ PEÑA", "15/03/1980", "1980-03-15", "Lima"} {
+		if strings.Contains(limpio, real) {
+			t.Errorf("quedó %q en %s", real, limpio)
+		}
+	}
+	for _, esperado := range []string{"00000009", grabacion.FechaFicticia, grabacion.FechaFicticiaISO, "Ciudad", `class="cruz"`} {
+		if !strings.Contains(limpio, esperado) {
+			t.Errorf("falta %q en %s", esperado, limpio)
+		}
+	}
+	if strings.ToUpper(l.Limpiar("José Luis")) != l.Limpiar("JOSÉ LUIS") {
+		t.Errorf("el mismo nombre se limpia distinto según las mayúsculas")
+	}
+}
@@ -0,0 +1,245 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FuenteReversion es la fuente con la que queda en el historial un valor
+// devuelto a su estado anterior
+const FuenteReversion = "revert"
+
+var (
+	corrida     string
+	corridaOnce sync.Once
+)
+
+// IDCorrida identifica en personas_history todas las escrituras de este
+// proceso: programa, hora de inicio y pid
+func IDCorrida() string {
+	corridaOnce.Do(func() {
+		corrida = fmt.Sprintf("%s-%s-%d", filepath.Base(os.Args[0]),
+			time.Now().UTC().Format("20060102T150405Z"), os.Getpid())
+	})
+	return corrida
+}
+
+// Cambio es una fila de personas_history
+type Cambio struct {
+	ID         int64
+	DNI        string
+	Campo      Campo
+	Anterior   sql.NullString
+	Nuevo      sql.NullString
+	Fuente     string
+	ObtenidoEn time.Time
+	Corrida    string
+	Confianza  sql.NullFloat64
+	Revision   sql.NullInt64  // revisión que decidió el cambio, si la hubo
+	Decision   sql.NullString // decisión de esa revisión
+	Autor      sql.NullString // quién la tomó
+	EscritoEn  time.Time
+}
+
+// cambio es un valor que se va a escribir en un campo; la fecha va en
+// formato aaaa-mm-dd
+type cambio struct {
+	DNI        string
+	Campo      Campo
+	Valor      sql.NullString
+	Fuente     string
+	ObtenidoEn time.Time
+	SoloVacio  bool    // solo se escribe si el campo está vacío
+	Confianza  float64 // 0 si no aplica, p. ej. en una reversión
+	// Una decisión de revisión se anota aunque el valor no cambie
+	Revision int64
+	Decision string
+	Autor    string
+}
+
+func (c cambio) confianza() sql.NullFloat64 {
+	return sql.NullFloat64{Float64: c.Confianza, Valid: c.Confianza > 0}
+}
+
+// columnaTexto es la expresión con el valor de c en personas p como texto,
+// igual a como se guarda en el historial
+func columnaTexto(c Campo) string {
+	if c == CampoFechaNacimiento {
+		return "to_char(p.fecha_nacimiento, 'YYYY-MM-DD')"
+	}
+	return "p." + string(c)
+}
+
+// valorAnterior elige la columna de personas p que nombra c.campo
+func valorAnterior() string {
+	return valorCampo("c.campo")
+}
+
+// valorCampo elige, como texto, la columna de personas p que nombra la
+// expresión campo
+func valorCampo(campo string) string {
+	var b strings.Builder
+	b.WriteString("CASE " + campo)
+	for _, campo := range Campos {
+		fmt.Fprintf(&b, " WHEN '%s' THEN %s", campo, columnaTexto(campo))
+	}
+	b.WriteString(" END")
+	return b.String()
+}
+
+// cambiosPorSentencia mantiene cada sentencia de registrarCambios por debajo
+// del límite de 65535 parámetros de PostgreSQL
+const cambiosPorSentencia = 5000
+
+// registrarCambios anota en personas_history y personas_procedencia los
+// cambios que de verdad modifican la fila, más las decisiones de revisión
+// aunque no la modifiquen. Se llama antes del UPDATE, cuando personas aún
+// tiene los valores anteriores.
+func registrarCambios(ctx context.Context, ex ejecutor, cambios []cambio) error {
+	for len(cambios) > cambiosPorSentencia {
+		if err := registrarCambios(ctx, ex, cambios[:cambiosPorSentencia]); err != nil {
+			return err
+		}
+		cambios = cambios[cambiosPorSentencia:]
+	}
+	if len(cambios) == 0 {
+		return nil
+	}
+	var filas []string
+	args := []interface{}{IDCorrida()}
+	for _, c := range cambios {
+		n := len(args) + 1
+		filas = append(filas, fmt.Sprintf("($%d::text, $%d::text, $%d::text, $%d::text, $%d::timestamptz, $%d::boolean, "+
+			"$%d::double precision, $%d::bigint, $%d::text, $%d::text)",
+			n, n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8, n+9))
+		args = append(args, c.DNI, string(c.Campo), c.Valor, c.Fuente, c.ObtenidoEn, c.SoloVacio, c.confianza(),
+			sql.NullInt64{Int64: c.Revision, Valid: c.Revision != 0}, nulo(c.Decision), nulo(c.Autor))
+	}
+	_, err := ex.ExecContext(ctx, `
+		WITH c (dni, campo, nuevo, fuente, obtenido_en, solo_vacio, confianza, revision, decision, autor) AS (
+			VALUES `+strings.Join(filas, ", ")+`
+		),
+		historial AS (
+			INSERT INTO personas_history (dni, field, old_value, new_value, source, fetched_at, run_id,
+			                              confidence, review_id, decision, author)
+			SELECT c.dni, c.campo, v.anterior, c.nuevo, c.fuente, c.obtenido_en, $1,
+			       c.confianza, c.revision, c.decision, c.autor
+			FROM c
+			JOIN personas p ON p.dni = c.dni
+			CROSS JOIN LATERAL (SELECT `+valorAnterior()+` AS anterior) v
+			WHERE (v.anterior IS DISTINCT FROM c.nuevo
+			       AND (NOT c.solo_vacio OR v.anterior IS NULL OR v.anterior = ''))
+			   OR c.decision IS NOT NULL
+			RETURNING dni, field, source, fetched_at, confidence, old_value IS DISTINCT FROM new_value AS modifica
+		)
+		INSERT INTO personas_procedencia (dni, campo, fuente, actualizado_en, confianza)
+		SELECT DISTINCT ON (dni, field) dni, field, source, fetched_at, confidence
+		FROM historial
+		WHERE modifica
+		ORDER BY dni, field, fetched_at DESC
+		ON CONFLICT (dni, campo) DO UPDATE
+		SET fuente = EXCLUDED.fuente, actualizado_en = EXCLUDED.actualizado_en,
+		    confianza = EXCLUDED.confianza`, args...)
+	return err
+}
+
+// HistorialDNI devuelve todos los cambios de dni, del más antiguo al más nuevo
+func HistorialDNI(ctx context.Context, db *sql.DB, dni string) ([]Cambio, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, dni, field, old_value, new_value, source, fetched_at, run_id, confidence,
+		       review_id, decision, author, written_at
+		FROM personas_history
+		WHERE dni = $1
+		ORDER BY id`, dni)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cambios []Cambio
+	for rows.Next() {
+		var c Cambio
+		var campo string
+		err := rows.Scan(&c.ID, &c.DNI, &campo, &c.Anterior, &c.Nuevo, &c.Fuente, &c.ObtenidoEn, &c.Corrida, &c.Confianza,
+			&c.Revision, &c.Decision, &c.Autor, &c.EscritoEn)
+		if err != nil {
+			return nil, err
+		}
+		c.Campo = Campo(campo)
+		cambios = append(cambios, c)
+	}
+	return cambios, rows.Err()
+}
+
+// RevertirCambio devuelve el campo del cambio id al valor que tenía antes.
+// Falla si el campo ya cambió otra vez, para no pisar un valor posterior.
+// La reversión queda en el historial como un cambio más.
+func RevertirCambio(ctx context.Context, db *sql.DB, id int64) (*Cambio, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var c Cambio
+	var campo string
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, dni, field, old_value, new_value
+		FROM personas_history WHERE id = $1`, id).
+		Scan(&c.ID, &c.DNI, &campo, &c.Anterior, &c.Nuevo)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("no hay un cambio con id %d", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.Campo = Campo(campo)
+	if !esCampo(c.Campo) {
+		return nil, fmt.Errorf("campo desconocido %q en el historial", campo)
+	}
+
+	var actual sql.NullString
+	err = tx.QueryRowContext(ctx, `SELECT `+columnaTexto(c.Campo)+` FROM personas p WHERE p.dni = $1 FOR UPDATE`, c.DNI).Scan(&actual)
+	if err != nil {
+		return nil, err
+	}
+	if actual != c.Nuevo {
+		return nil, fmt.Errorf("el campo %s de %s ya no tiene el valor de ese cambio (ahora %q)", c.Campo, c.DNI, actual.String)
+	}
+
+	err = registrarCambios(ctx, tx, []cambio{{
+		DNI: c.DNI, Campo: c.Campo, Valor: c.Anterior, Fuente: FuenteReversion, ObtenidoEn: time.Now(),
+	}})
+	if err != nil {
+		return nil, err
+	}
+	if err := escribirCampo(ctx, tx, c.DNI, c.Campo, c.Anterior); err != nil {
+		return nil, err
+	}
+	return &c, tx.Commit()
+}
+
+// escribirCampo pone valor, en el formato del historial, en el campo c de dni
+func escribirCampo(ctx context.Context, ex ejecutor, dni string, c Campo, valor sql.NullString) error {
+	tipo := "$1::text"
+	if c == CampoFechaNacimiento {
+		tipo = "$1::date"
+	}
+	_, err := ex.ExecContext(ctx, fmt.Sprintf("UPDATE personas SET %s = %s WHERE dni = $2", c, tipo), valor, dni)
+	return err
+}
+
+func esCampo(c Campo) bool {
+	for _, campo := range Campos {
+		if c == campo {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package core
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"time"
+)
+
+// NuevoClienteHTTP crea un cliente con su propio jar de cookies, de modo que
+// cada worker mantenga una sesión independiente
+func NuevoClienteHTTP(timeout time.Duration) *http.Client {
+	jar, _ := cookiejar.New(nil)
+	return &http.Client{Timeout: timeout, Jar: jar}
+}
+
+// SetHeaders simula las cabeceras de un navegador real
+func SetHeaders(req *http.Request) {
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,image/apng,*/*;q=0.8")
+	req.Header.Set("Accept-Language", "es-PE,es;q=0.9,en;q=0.8")
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	req.Header.Set("Connection", "keep-alive")
+	req.Header.Set("Upgrade-Insecure-Requests", "1")
+	req.Header.Set("Cache-Control", "no-cache")
+	req.Header.Set("Pragma", "no-cache")
+	req.Header.Set("Sec-Fetch-Dest", "document")
+	req.Header.Set("Sec-Fetch-Mode", "navigate")
+	req.Header.Set("Sec-Fetch-Site", "none")
+	req.Header.Set("Sec-Fetch-User", "?1")
+}
+
+// LeerRespuesta lee el cuerpo descomprimiendo gzip cuando corresponde
+func LeerRespuesta(resp *http.Response) ([]byte, error) {
+	var reader io.Reader = resp.Body
+
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		gzipReader, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+
+	return io.ReadAll(reader)
+}
+
+// ExtraerToken extrae el token CSRF (_token) de un formulario HTML de
+// eldni.com con las reglas embebidas
+func ExtraerToken(html string) (string, error) {
+	return extraerToken(html, ReglasDefecto()[NombreEldniDatos])
+}
+
+func extraerToken(html string, reglas *ReglasFuente) (string, error) {
+	token, err := reglas.ExtraerToken(html)
+	if err != nil {
+		return "", err
+	}
+	if token == "" {
+		return "", fmt.Errorf("%w: ninguna regla de %s v%d encontró el token", ErrTokenMissing, reglas.Fuente, reglas.Version)
+	}
+	return token, nil
+}
+
+// ObtenerToken descarga el formulario de eldni.com y devuelve su token CSRF
+func ObtenerToken(ctx context.Context, client *http.Client, targetURL string) (string, error) {
+	return obtenerToken(ctx, client, targetURL, ReglasDefecto()[NombreEldniDatos], RelojSistema)
+}
+
+func obtenerToken(ctx context.Context, client *http.Client, targetURL string, reglas *ReglasFuente, reloj Reloj) (string, error) {
+	defer marcarWorker(ctx, WorkerRenovandoToken, "")()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", targetURL, nil)
+	if err != nil {
+		return "", err
+	}
+	SetHeaders(req)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if err := errorDeEstado(resp, reloj); err != nil {
+		return "", err
+	}
+
+	body, err := LeerRespuesta(resp)
+	if err != nil {
+		return "", err
+	}
+
+	return extraerToken(string(body), reglas)
+}
@@ -0,0 +1,214 @@
+package core
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// EsperaLimiteDefecto es cuánto se frena un host que responde 429/503 sin
+// indicar Retry-After
+const EsperaLimiteDefecto = 30 * time.Second
+
+// Cuota es el ritmo permitido para una fuente: PorMinuto consultas en
+// promedio con ráfagas de hasta Rafaga seguidas. PorMinuto 0 es sin límite.
+type Cuota struct {
+	PorMinuto float64 `yaml:"por_minuto"`
+	Rafaga    int     `yaml:"rafaga"`
+}
+
+// LimitesDefecto son las cuotas publicadas o, a falta de ellas, las que se
+// observó que los sitios toleran
+func LimitesDefecto() map[string]Cuota {
+	return map[string]Cuota{
+		NombreEldniDatos:  {PorMinuto: 30, Rafaga: 3},
+		NombreEldniDigito: {PorMinuto: 30, Rafaga: 3},
+		NombreDniperu:     {PorMinuto: 5, Rafaga: 1},
+	}
+}
+
+// Limitador es un token bucket para un host. Lo comparten todos los workers
+// que consultan ese host, y un 429/503 lo frena para todos a la vez.
+type Limitador struct {
+	mu         sync.Mutex
+	host       string
+	tasa       float64 // tokens por segundo
+	rafaga     float64
+	tokens     float64
+	ultimo     time.Time
+	pausaHasta time.Time
+	reloj      Reloj
+}
+
+// NuevoLimitador crea el limitador de host con la cuota c; mide el tiempo y
+// espera con r, o con el reloj del sistema si r es nil
+func NuevoLimitador(host string, c Cuota, r Reloj) *Limitador {
+	if r == nil {
+		r = RelojSistema
+	}
+	l := &Limitador{host: host, reloj: r, ultimo: r.Ahora()}
+	l.ajustar(c)
+	l.tokens = l.rafaga
+	return l
+}
+
+func (l *Limitador) ajustar(c Cuota) {
+	l.tasa = c.PorMinuto / 60
+	l.rafaga = float64(c.Rafaga)
+	if l.rafaga < 1 {
+		l.rafaga = 1
+	}
+}
+
+// Esperar bloquea hasta que haya un token disponible y haya pasado cualquier
+// pausa pedida por el servidor
+func (l *Limitador) Esperar(ctx context.Context) error {
+	inicio := l.reloj.Ahora()
+	defer func() {
+		if d := l.reloj.Ahora().Sub(inicio); d > time.Millisecond {
+			metricaEsperaLimite.WithLabelValues(l.host).Add(d.Seconds())
+		}
+	}()
+
+	restaurar := func() {}
+	defer func() { restaurar() }()
+	for {
+		espera := l.reservar(l.reloj.Ahora())
+		if espera <= 0 {
+			return nil
+		}
+		if espera > time.Millisecond {
+			restaurar()
+			restaurar = marcarWorker(ctx, WorkerEsperandoLimite, l.host)
+		}
+		if err := l.reloj.Dormir(ctx, espera); err != nil {
+			return err
+		}
+	}
+}
+
+// reservar toma un token si puede; si no, devuelve cuánto falta para el próximo
+func (l *Limitador) reservar(ahora time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if ahora.Before(l.pausaHasta) {
+		return l.pausaHasta.Sub(ahora)
+	}
+	if l.tasa <= 0 {
+		return 0
+	}
+
+	l.tokens += ahora.Sub(l.ultimo).Seconds() * l.tasa
+	if l.tokens > l.rafaga {
+		l.tokens = l.rafaga
+	}
+	l.ultimo = ahora
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+	return time.Duration((1 - l.tokens) / l.tasa * float64(time.Second))
+}
+
+// Frenar detiene todas las consultas al host durante d y vacía el bucket
+// para que al reanudar no salga una ráfaga
+func (l *Limitador) Frenar(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	metricaFrenos.WithLabelValues(l.host).Inc()
+	hasta := l.reloj.Ahora().Add(d)
+	if hasta.After(l.pausaHasta) {
+		l.pausaHasta = hasta
+		slog.Warn("el servidor pidió bajar el ritmo", "host", l.host, "pausa", d)
+	}
+	l.tokens = 0
+	l.ultimo = hasta
+}
+
+// Limitadores reparte un Limitador por host
+type Limitadores struct {
+	mu     sync.Mutex
+	cuotas map[string]Cuota
+	hosts  map[string]*Limitador
+	reloj  Reloj
+}
+
+// NuevosLimitadores usa cuotas, indexadas por nombre de fuente, para
+// configurar el limitador del host de cada fuente
+func NuevosLimitadores(cuotas map[string]Cuota) *Limitadores {
+	return NuevosLimitadoresConReloj(cuotas, RelojSistema)
+}
+
+// NuevosLimitadoresConReloj es NuevosLimitadores con los limitadores
+// midiendo el tiempo y esperando con r
+func NuevosLimitadoresConReloj(cuotas map[string]Cuota, r Reloj) *Limitadores {
+	return &Limitadores{cuotas: cuotas, hosts: make(map[string]*Limitador), reloj: r}
+}
+
+// Para devuelve el limitador del host de rawURL con la cuota de fuente. Si
+// dos fuentes comparten host se queda con la cuota más estricta.
+func (ls *Limitadores) Para(fuente, rawURL string) *Limitador {
+	host := rawURL
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	c := ls.cuotas[fuente]
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	l, ok := ls.hosts[host]
+	if !ok {
+		l = NuevoLimitador(host, c, ls.reloj)
+		ls.hosts[host] = l
+		return l
+	}
+
+	l.mu.Lock()
+	if c.PorMinuto > 0 && (l.tasa <= 0 || c.PorMinuto/60 < l.tasa) {
+		l.ajustar(c)
+	}
+	l.mu.Unlock()
+	return l
+}
+
+// transporteLimitado espera turno en el limitador antes de cada petición y
+// lo frena cuando el servidor responde 429 o 503
+type transporteLimitado struct {
+	base      http.RoundTripper
+	limitador *Limitador
+}
+
+func (t *transporteLimitado) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limitador.Esperar(req.Context()); err != nil {
+		return nil, err
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		espera := leerRetryAfter(resp.Header.Get("Retry-After"), t.limitador.reloj.Ahora())
+		if espera == 0 {
+			espera = EsperaLimiteDefecto
+		}
+		t.limitador.Frenar(espera)
+	}
+	return resp, nil
+}
+
+// FrenarCliente frena el limitador de client, si tiene uno. Sirve para los
+// sitios que avisan del límite en el cuerpo de la respuesta y no con un 429.
+func FrenarCliente(client *http.Client, d time.Duration) {
+	if t, ok := client.Transport.(*transporteLimitado); ok {
+		t.limitador.Frenar(d)
+	}
+}
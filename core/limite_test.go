@@ -0,0 +1,90 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// relojFijo avanza solo cuando alguien duerme
+type relojFijo struct{ ahora time.Time }
+
+func (r *relojFijo) Ahora() time.Time { return r.ahora }
+
+func (r *relojFijo) Dormir(ctx context.Context, d time.Duration) error {
+	r.ahora = r.ahora.Add(d)
+	return ctx.Err()
+}
+
+// TestLimitadorReloj comprueba que la espera del limitador y un Retry-After
+// con fecha se miden con el reloj inyectado y no con la hora del sistema
+func TestLimitadorReloj(t *testing.T) {
+	r := &relojFijo{ahora: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	inicio := r.ahora
+	l := NuevoLimitador("ejemplo", Cuota{PorMinuto: 60, Rafaga: 1}, r)
+
+	for i := 0; i < 3; i++ {
+		if err := l.Esperar(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := r.ahora.Sub(inicio); d != 2*time.Second {
+		t.Errorf("tres consultas a 1/s esperaron %v, se esperaba 2s", d)
+	}
+
+	fecha := r.ahora.Add(90 * time.Second).Format(http.TimeFormat)
+	if d := leerRetryAfter(fecha, r.Ahora()); d != 90*time.Second {
+		t.Errorf("Retry-After %q = %v, se esperaba 90s", fecha, d)
+	}
+}
+
+// TestReintentoReloj simula un 429 con Retry-After: el reintento espera lo
+// que pide el servidor, el limitador lo respeta y todo corre en el reloj
+// inyectado; una espera que no entra en el plazo corta sin dormir
+func TestReintentoReloj(t *testing.T) {
+	peticiones := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		peticiones++
+		if peticiones == 1 {
+			w.Header().Set("Retry-After", "10")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer srv.Close()
+
+	r := &relojFijo{ahora: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	inicio := r.ahora
+	client := &http.Client{Transport: &transporteLimitado{
+		base:      http.DefaultTransport,
+		limitador: NuevoLimitador("ejemplo", Cuota{PorMinuto: 60, Rafaga: 1}, r),
+	}}
+	p := PoliticaReintento{MaxIntentos: 3, EsperaBase: time.Second, EsperaMax: time.Second, Plazo: time.Minute, Reloj: r}
+
+	intentos, err := p.Ejecutar(context.Background(), func(ctx context.Context) error {
+		req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		return errorDeEstado(resp, r)
+	})
+	if err != nil || intentos != 2 || peticiones != 2 {
+		t.Fatalf("%d intentos, %d peticiones, error %v", intentos, peticiones, err)
+	}
+	// 10s del Retry-After y 1s hasta que el limitador, vaciado, da un token
+	if d := r.ahora.Sub(inicio); d != 11*time.Second {
+		t.Errorf("el reintento tardó %v en el reloj, se esperaba 11s", d)
+	}
+
+	antes := r.ahora
+	_, err = p.Ejecutar(context.Background(), func(context.Context) error {
+		return &RateLimitError{Status: http.StatusTooManyRequests, RetryAfter: 2 * time.Minute}
+	})
+	if !errors.Is(err, context.DeadlineExceeded) || r.ahora != antes {
+		t.Errorf("un Retry-After mayor que el plazo: error %v tras %v", err, r.ahora.Sub(antes))
+	}
+}
@@ -0,0 +1,180 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Formatos de log
+const (
+	LogTexto = "text"
+	LogJSON  = "json"
+)
+
+// LogConfig elige cómo se escriben los logs. En modo silencioso solo se ven
+// los errores, el progreso en la terminal y el resumen final. Con ClaveDNI
+// los DNIs van en los logs como un HMAC con esa clave; sin ella no van.
+type LogConfig struct {
+	Formato    string `yaml:"formato"` // text o json
+	Nivel      string `yaml:"nivel"`   // debug, info, warn o error
+	Silencioso bool   `yaml:"silencioso"`
+	ClaveDNI   string `yaml:"clave_dni"`
+}
+
+// claveDNI es la clave del HMAC de los DNIs; se fija al configurar los logs,
+// antes de que arranquen los workers
+var claveDNI []byte
+
+// ConfigurarLogs instala en slog el logger que pide c, escribiendo a stderr
+// para que la salida estándar quede para los resúmenes. Lo que se escribe
+// con el paquete log (los log.Fatalf de los programas) sale como error, para
+// que se vea con cualquier nivel y en modo silencioso.
+func ConfigurarLogs(c LogConfig) error {
+	logger, err := NuevoLogger(salida, c)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	slog.SetLogLoggerLevel(slog.LevelError)
+	claveDNI = []byte(c.ClaveDNI)
+	return nil
+}
+
+func NuevoLogger(w io.Writer, c LogConfig) (*slog.Logger, error) {
+	var nivel slog.Level
+	if c.Nivel != "" {
+		if err := nivel.UnmarshalText([]byte(c.Nivel)); err != nil {
+			return nil, fmt.Errorf("nivel de log inválido %q", c.Nivel)
+		}
+	}
+	if c.Silencioso && nivel < slog.LevelError {
+		nivel = slog.LevelError
+	}
+
+	opts := &slog.HandlerOptions{Level: nivel}
+	switch strings.ToLower(c.Formato) {
+	case "", LogTexto:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case LogJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("formato de log desconocido %q (usar %s o %s)", c.Formato, LogTexto, LogJSON)
+}
+
+// salidaLogs es el stderr de los logs. Mientras haya un panel de progreso
+// en la terminal lo borra antes de cada línea y lo vuelve a dibujar debajo.
+type salidaLogs struct {
+	mu     sync.Mutex
+	w      io.Writer
+	panel  *Progreso
+	lineas int // líneas del panel en pantalla
+}
+
+var salida = &salidaLogs{w: os.Stderr}
+
+func (s *salidaLogs) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.borrar()
+	n, err := s.w.Write(b)
+	s.dibujar()
+	return n, err
+}
+
+// redibujar actualiza el panel en su sitio
+func (s *salidaLogs) redibujar() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.borrar()
+	s.dibujar()
+}
+
+// soltar deja el último panel en pantalla y vuelve a escribir los logs
+// sin tocarlo
+func (s *salidaLogs) soltar(p *Progreso) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.panel == p {
+		s.borrar()
+		s.dibujar()
+		s.panel, s.lineas = nil, 0
+	}
+}
+
+func (s *salidaLogs) borrar() {
+	if s.lineas > 0 {
+		fmt.Fprintf(s.w, "\x1b[%dF\x1b[J", s.lineas)
+		s.lineas = 0
+	}
+}
+
+func (s *salidaLogs) dibujar() {
+	if s.panel == nil {
+		return
+	}
+	texto := s.panel.panel()
+	s.lineas = strings.Count(texto, "\n")
+	io.WriteString(s.w, texto)
+}
+
+// esTerminal indica si f es una terminal y no un archivo o una tubería
+func esTerminal(f *os.File) bool {
+	st, err := f.Stat()
+	return err == nil && st.Mode()&os.ModeCharDevice != 0
+}
+
+// HashDNI identifica un DNI en los logs sin escribirlo en claro: un HMAC
+// con la clave configurada, que sin ella no se puede recalcular probando los
+// 10^8 DNIs. Sin clave devuelve "".
+func HashDNI(dni string) string {
+	if len(claveDNI) == 0 {
+		return ""
+	}
+	mac := hmac.New(sha256.New, claveDNI)
+	mac.Write([]byte(dni))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// AtributoDNI es el dni_hash de dni para un log; sin clave es un atributo
+// vacío, que slog omite
+func AtributoDNI(dni string) slog.Attr {
+	h := HashDNI(dni)
+	if h == "" {
+		return slog.Attr{}
+	}
+	return slog.String("dni_hash", h)
+}
+
+// AtributosConsulta son los campos que acompañan cada consulta en los logs
+func AtributosConsulta(dni, fuente string, intentos int, duracion time.Duration, err error) []any {
+	return []any{
+		AtributoDNI(dni),
+		slog.String("source", fuente),
+		slog.Int("attempt", intentos),
+		slog.Int64("latency_ms", duracion.Milliseconds()),
+		slog.String("outcome", ClasificarResultado(err)),
+	}
+}
+
+// AtributosConflicto son los campos que acompañan en los logs un campo que
+// queda en revisión; los valores no se loguean, solo las fuentes
+func AtributosConflicto(c Conflicto) []any {
+	fuentes := make([]string, len(c.Candidatos))
+	for i, candidato := range c.Candidatos {
+		fuentes[i] = candidato.Fuente
+	}
+	return []any{
+		AtributoDNI(c.DNI),
+		slog.String("field", string(c.Campo)),
+		slog.String("reason", c.Motivo),
+		slog.String("sources", strings.Join(fuentes, ",")),
+	}
+}
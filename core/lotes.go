@@ -0,0 +1,582 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// UmbralCopia es el tamaño de lote a partir del cual las filas se cargan con
+// COPY en una tabla temporal y se aplican con un solo UPDATE ... FROM
+const UmbralCopia = 50
+
+// LotesConfig controla cómo se agrupan las escrituras de resultados: se
+// manda una transacción cuando se juntan Tamano o cada Intervalo
+type LotesConfig struct {
+	Tamano    int           `yaml:"tamano"`
+	Intervalo time.Duration `yaml:"intervalo"`
+}
+
+func LotesDefecto() LotesConfig {
+	return LotesConfig{Tamano: 100, Intervalo: 2 * time.Second}
+}
+
+// PoliticaReintentoBD reintenta un lote que falló por un conflicto de
+// serialización, un deadlock o una conexión caída
+func PoliticaReintentoBD() PoliticaReintento {
+	return PoliticaReintento{
+		MaxIntentos:  5,
+		EsperaBase:   200 * time.Millisecond,
+		EsperaMax:    5 * time.Second,
+		Plazo:        time.Minute,
+		Reintentable: EsErrorPasajeroBD,
+	}
+}
+
+// EsErrorPasajeroBD indica si vale la pena repetir la transacción que
+// devolvió err
+func EsErrorPasajeroBD(err error) bool {
+	var errPQ *pq.Error
+	if errors.As(err, &errPQ) {
+		// 40001 serialization_failure, 40P01 deadlock_detected, 08 conexión
+		return errPQ.Code == "40001" || errPQ.Code == "40P01" || errPQ.Code.Class() == "08"
+	}
+	var errRed net.Error
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.As(err, &errRed)
+}
+
+// Escritura es el resultado de una consulta listo para guardar
+type Escritura struct {
+	// Persona lleva el DNI y los campos obtenidos; los vacíos no se tocan y
+	// la fecha (dd/mm/aaaa) solo se escribe si el DNI aún no la tiene. Los
+	// nombres que no coinciden con los registrados no se escriben: quedan en
+	// personas_review.
+	Persona Persona
+	// NombreCompleto es el nombre sin separar que dio la fuente, si solo da
+	// eso; no se escribe, solo se contrasta con los nombres registrados
+	NombreCompleto string
+	// Fuente y Error se registran en lookup_outcomes; Fuente vacía no
+	// registra nada. Los campos que cambien quedan en personas_history con
+	// Fuente y ObtenidoEn (por defecto, cuando se agregó).
+	Fuente     string
+	Error      error
+	ObtenidoEn time.Time
+	// Listo, si no es nil, se llama tras guardar el lote con el error de la
+	// escritura y si se modificó la fila. Se llama desde la goroutine del
+	// escritor, en el orden en que se agregaron.
+	Listo func(actualizada bool, err error)
+}
+
+func (e Escritura) tieneCampos() bool {
+	p := e.Persona
+	return p.Nombres != "" || p.ApellidoPaterno != "" || p.ApellidoMaterno != "" ||
+		p.FechaNacimiento != "" || p.CodigoVerif != ""
+}
+
+// EscritorLotes junta escrituras y las guarda en transacciones, para no
+// hacer un viaje a la base por cada DNI
+type EscritorLotes struct {
+	ctx        context.Context
+	db         *sql.DB
+	c          LotesConfig
+	reconsulta Reconsulta
+	politica   PoliticaReintento
+
+	mu         sync.Mutex
+	pendientes []Escritura
+	lleno      chan struct{}
+	cerrar     chan struct{}
+	listo      chan struct{}
+}
+
+// NuevoEscritorLotes empieza a guardar en db, con ctx, las escrituras que se
+// agreguen; hay que llamar a Cerrar para guardar las últimas
+func NuevoEscritorLotes(ctx context.Context, db *sql.DB, c LotesConfig, r Reconsulta) *EscritorLotes {
+	if c.Tamano < 1 {
+		c.Tamano = 1
+	}
+	if c.Intervalo <= 0 {
+		c.Intervalo = LotesDefecto().Intervalo
+	}
+	w := &EscritorLotes{
+		ctx:        ctx,
+		db:         db,
+		c:          c,
+		reconsulta: r,
+		politica:   PoliticaReintentoBD(),
+		lleno:      make(chan struct{}, 1),
+		cerrar:     make(chan struct{}),
+		listo:      make(chan struct{}),
+	}
+	go w.correr()
+	return w
+}
+
+// Agregar encola e para el próximo lote
+func (w *EscritorLotes) Agregar(e Escritura) {
+	if e.ObtenidoEn.IsZero() {
+		e.ObtenidoEn = time.Now()
+	}
+	w.mu.Lock()
+	w.pendientes = append(w.pendientes, e)
+	lleno := len(w.pendientes) >= w.c.Tamano
+	w.mu.Unlock()
+
+	if lleno {
+		select {
+		case w.lleno <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Cerrar guarda lo pendiente y espera a que termine el último lote
+func (w *EscritorLotes) Cerrar() {
+	close(w.cerrar)
+	<-w.listo
+}
+
+func (w *EscritorLotes) correr() {
+	defer close(w.listo)
+	ticker := time.NewTicker(w.c.Intervalo)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.lleno:
+		case <-ticker.C:
+		case <-w.cerrar:
+			w.vaciar()
+			return
+		}
+		w.vaciar()
+	}
+}
+
+// vaciar guarda todo lo pendiente, en lotes de a lo sumo Tamano
+func (w *EscritorLotes) vaciar() {
+	for {
+		w.mu.Lock()
+		n := min(len(w.pendientes), w.c.Tamano)
+		lote := w.pendientes[:n:n]
+		w.pendientes = w.pendientes[n:]
+		w.mu.Unlock()
+		if n == 0 {
+			return
+		}
+		w.guardar(lote)
+	}
+}
+
+func (w *EscritorLotes) guardar(lote []Escritura) {
+	defer medirEscritura("lote", time.Now())
+
+	filas, apartados, errores := prepararLote(lote)
+	resultados := conErrores(lote, errores)
+
+	var actualizadas map[string]bool
+	var conflictos []Conflicto
+	_, err := w.politica.Ejecutar(w.ctx, func(ctx context.Context) error {
+		var err error
+		actualizadas, conflictos, err = w.transaccion(ctx, resultados, filas, apartados)
+		return err
+	})
+	if err == nil {
+		for _, c := range conflictos {
+			slog.Warn("nombre en conflicto, queda en revisión", AtributosConflicto(c)...)
+		}
+	}
+
+	for i, e := range lote {
+		if e.Listo == nil {
+			continue
+		}
+		switch {
+		case errores[i] != nil:
+			e.Listo(false, errores[i])
+		case err != nil:
+			e.Listo(false, err)
+		default:
+			e.Listo(actualizadas[e.Persona.DNI], nil)
+		}
+	}
+}
+
+// prepararLote arma las filas a actualizar. Las fechas inválidas se
+// descartan antes, con un ErrParse en errores, para no perder el lote
+// entero, y los valores de respaldos frágiles se apartan para revisión.
+func prepararLote(lote []Escritura) (filas []filaLote, apartados []Conflicto, errores []error) {
+	errores = make([]error, len(lote))
+	filas = make([]filaLote, 0, len(lote))
+	for i, e := range lote {
+		apartados = append(apartados, apartarFragiles(&e.Persona, e.Fuente)...)
+		if !e.tieneCampos() && e.NombreCompleto == "" {
+			continue
+		}
+		f := filaLote{Persona: e.Persona, nombreCompleto: e.NombreCompleto, fuente: e.Fuente, obtenidoEn: e.ObtenidoEn}
+		if e.Persona.FechaNacimiento != "" {
+			fecha, err := ConvertirFecha(e.Persona.FechaNacimiento)
+			if err != nil {
+				errores[i] = fmt.Errorf("%w: fecha de nacimiento: %v", ErrParse, err)
+				continue
+			}
+			f.fechaSQL = fecha
+		}
+		filas = append(filas, f)
+	}
+	return filas, apartados, errores
+}
+
+// conErrores devuelve una copia de lote con los errores de prepararLote, para
+// que lookup_outcomes registre como fallida la consulta cuyo valor no se
+// pudo escribir
+func conErrores(lote []Escritura, errores []error) []Escritura {
+	resultados := make([]Escritura, len(lote))
+	copy(resultados, lote)
+	for i, err := range errores {
+		if err != nil && resultados[i].Error == nil {
+			resultados[i].Error = err
+		}
+	}
+	return resultados
+}
+
+// filaLote es una fila a actualizar con la fecha ya en formato SQL
+type filaLote struct {
+	Persona
+	nombreCompleto string
+	fechaSQL       string
+	fuente         string
+	obtenidoEn     time.Time
+	confianza      map[Campo]float64 // la de los nombres ya contrastados
+}
+
+// cambios son los valores de f para el historial
+func (f filaLote) cambios() []cambio {
+	var cambios []cambio
+	for _, c := range Campos {
+		valor := f.Valor(c)
+		if c == CampoFechaNacimiento {
+			valor = f.fechaSQL
+		}
+		if valor == "" {
+			continue
+		}
+		confianza, ok := f.confianza[c]
+		if !ok {
+			confianza = ConfianzaFuente(f.fuente)
+		}
+		cambios = append(cambios, cambio{
+			DNI: f.DNI, Campo: c, Valor: nulo(valor), Fuente: f.fuente,
+			ObtenidoEn: f.obtenidoEn, SoloVacio: c == CampoFechaNacimiento, Confianza: confianza,
+		})
+	}
+	return cambios
+}
+
+// transaccion aplica el lote, deja en revisión los apartados y devuelve los
+// DNIs cuya fila cambió y los nombres que quedaron en revisión
+func (w *EscritorLotes) transaccion(ctx context.Context, lote []Escritura, filas []filaLote, apartados []Conflicto) (map[string]bool, []Conflicto, error) {
+	tx, err := w.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	filas, conflictos, err := contrastarNombresLote(ctx, tx, filas)
+	if err != nil {
+		return nil, nil, err
+	}
+	revision := append(append([]Conflicto(nil), apartados...), conflictos...)
+	if err := registrarConflictos(ctx, tx, revision); err != nil {
+		return nil, nil, err
+	}
+
+	var actualizadas map[string]bool
+	if porCopia(len(filas)) {
+		actualizadas, err = actualizarPorCopia(ctx, tx, filas)
+	} else {
+		actualizadas, err = actualizarPorFila(ctx, tx, filas)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if porCopia(len(lote)) {
+		err = registrarResultadosPorCopia(ctx, tx, lote, w.reconsulta)
+	} else {
+		for _, e := range lote {
+			if e.Fuente == "" {
+				continue
+			}
+			if err = registrarResultado(ctx, tx, e.Persona.DNI, e.Fuente, e.Error, w.reconsulta); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return actualizadas, conflictos, tx.Commit()
+}
+
+// porCopia indica si n filas se cargan con COPY en vez de una por una
+func porCopia(n int) bool {
+	return n >= UmbralCopia
+}
+
+// contrastarNombresLote compara los nombres de las filas con los que ya
+// están en personas y devuelve una copia de las filas sin los que
+// discrepan; filas no se toca, para que un reintento vuelva a contrastar
+// todo. Bloquea las filas contrastadas hasta el final de la transacción.
+func contrastarNombresLote(ctx context.Context, tx *sql.Tx, filas []filaLote) ([]filaLote, []Conflicto, error) {
+	var dnis []string
+	for _, f := range filas {
+		if f.Nombres != "" || f.ApellidoPaterno != "" || f.ApellidoMaterno != "" || f.nombreCompleto != "" {
+			dnis = append(dnis, f.DNI)
+		}
+	}
+	if len(dnis) == 0 {
+		return filas, nil, nil
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT dni, COALESCE(nombres, ''), COALESCE(apellido_paterno, ''), COALESCE(apellido_materno, '')
+		FROM personas WHERE dni = ANY($1)
+		ORDER BY dni
+		FOR UPDATE`, pq.Array(dnis))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	registrados := make(map[string]Persona)
+	for rows.Next() {
+		var p Persona
+		if err := rows.Scan(&p.DNI, &p.Nombres, &p.ApellidoPaterno, &p.ApellidoMaterno); err != nil {
+			return nil, nil, err
+		}
+		registrados[p.DNI] = p
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	contrastadas := make([]filaLote, len(filas))
+	copy(contrastadas, filas)
+	var conflictos []Conflicto
+	for i := range contrastadas {
+		f := &contrastadas[i]
+		registrado, ok := registrados[f.DNI]
+		if !ok {
+			continue
+		}
+		evidencias := []Evidencia{evidenciaRegistrada(registrado), {Fuente: f.fuente, Persona: f.Persona}}
+		if f.nombreCompleto != "" {
+			evidencias = append(evidencias, Evidencia{Fuente: f.fuente, NombreCompleto: f.nombreCompleto})
+		}
+		confianza, discrepan := ContrastarNombres(f.DNI, evidencias)
+		for _, c := range discrepan {
+			f.Asignar(c.Campo, "")
+		}
+		f.confianza = confianza
+		conflictos = append(conflictos, discrepan...)
+	}
+	return contrastadas, conflictos, nil
+}
+
+// Los nombres y el código se reemplazan; la fecha solo se completa
+const (
+	asignacionesLote = `
+		nombres = COALESCE(s.nombres, p.nombres),
+		apellido_paterno = COALESCE(s.apellido_paterno, p.apellido_paterno),
+		apellido_materno = COALESCE(s.apellido_materno, p.apellido_materno),
+		fecha_nacimiento = COALESCE(p.fecha_nacimiento, s.fecha_nacimiento),
+		codigo_verificador = COALESCE(s.codigo_verificador, p.codigo_verificador)`
+	cambiaFila = `
+		(s.nombres IS NOT NULL OR s.apellido_paterno IS NOT NULL OR s.apellido_materno IS NOT NULL
+		 OR s.codigo_verificador IS NOT NULL
+		 OR (s.fecha_nacimiento IS NOT NULL AND p.fecha_nacimiento IS NULL))`
+)
+
+func actualizarPorFila(ctx context.Context, tx *sql.Tx, filas []filaLote) (map[string]bool, error) {
+	actualizadas := make(map[string]bool)
+	if len(filas) == 0 {
+		return actualizadas, nil
+	}
+
+	var cambios []cambio
+	for _, f := range filas {
+		cambios = append(cambios, f.cambios()...)
+	}
+	if err := registrarCambios(ctx, tx, cambios); err != nil {
+		return nil, err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `
+		UPDATE personas p SET`+asignacionesLote+`
+		FROM (SELECT $1::text AS dni, $2::text AS nombres, $3::text AS apellido_paterno,
+		             $4::text AS apellido_materno, $5::date AS fecha_nacimiento,
+		             $6::text AS codigo_verificador) s
+		WHERE p.dni = s.dni AND`+cambiaFila)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	for _, f := range filas {
+		res, err := stmt.ExecContext(ctx, f.DNI, nulo(f.Nombres), nulo(f.ApellidoPaterno),
+			nulo(f.ApellidoMaterno), nulo(f.fechaSQL), nulo(f.CodigoVerif))
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			actualizadas[f.DNI] = true
+		}
+	}
+	return actualizadas, nil
+}
+
+// actualizarPorCopia carga las filas con COPY en una tabla temporal y las
+// aplica con un solo UPDATE. Si un DNI se repite, gana su última fila.
+func actualizarPorCopia(ctx context.Context, tx *sql.Tx, filas []filaLote) (map[string]bool, error) {
+	_, err := tx.ExecContext(ctx, `
+		CREATE TEMP TABLE personas_lote (
+			orden              INTEGER     NOT NULL,
+			dni                TEXT        NOT NULL,
+			nombres            TEXT,
+			apellido_paterno   TEXT,
+			apellido_materno   TEXT,
+			fecha_nacimiento   DATE,
+			codigo_verificador TEXT,
+			fuente             TEXT        NOT NULL,
+			obtenido_en        TIMESTAMPTZ NOT NULL
+		) ON COMMIT DROP`)
+	if err != nil {
+		return nil, err
+	}
+
+	valores := make([][]any, len(filas))
+	for i, f := range filas {
+		valores[i] = []any{i, f.DNI, nulo(f.Nombres), nulo(f.ApellidoPaterno),
+			nulo(f.ApellidoMaterno), nulo(f.fechaSQL), nulo(f.CodigoVerif), f.fuente, f.obtenidoEn}
+	}
+	err = copiar(ctx, tx, "personas_lote", []string{"orden", "dni", "nombres", "apellido_paterno",
+		"apellido_materno", "fecha_nacimiento", "codigo_verificador", "fuente", "obtenido_en"}, valores)
+	if err != nil {
+		return nil, err
+	}
+
+	// El historial, con la última fila de cada DNI como el UPDATE
+	ultima := make(map[string]int)
+	for i, f := range filas {
+		ultima[f.DNI] = i
+	}
+	var cambios []cambio
+	for i, f := range filas {
+		if ultima[f.DNI] == i {
+			cambios = append(cambios, f.cambios()...)
+		}
+	}
+	if err := registrarCambios(ctx, tx, cambios); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		UPDATE personas p SET`+asignacionesLote+`
+		FROM (SELECT DISTINCT ON (dni) * FROM personas_lote ORDER BY dni, orden DESC) s
+		WHERE p.dni = s.dni AND`+cambiaFila+`
+		RETURNING p.dni`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	actualizadas := make(map[string]bool)
+	for rows.Next() {
+		var dni string
+		if err := rows.Scan(&dni); err != nil {
+			return nil, err
+		}
+		actualizadas[dni] = true
+	}
+	return actualizadas, rows.Err()
+}
+
+// registrarResultadosPorCopia hace lo mismo que registrarResultado para
+// todo el lote con un COPY y un solo INSERT
+func registrarResultadosPorCopia(ctx context.Context, tx *sql.Tx, lote []Escritura, r Reconsulta) error {
+	var valores [][]any
+	for i, e := range lote {
+		if e.Fuente == "" {
+			continue
+		}
+		resultado, detalle, reconsultar := valoresResultado(e.Error, r)
+		if resultado == "" {
+			continue
+		}
+		valores = append(valores, []any{i, e.Persona.DNI, e.Fuente, resultado, detalle, reconsultar})
+	}
+	if len(valores) == 0 {
+		return nil
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		CREATE TEMP TABLE resultados_lote (
+			orden       INTEGER NOT NULL,
+			dni         TEXT    NOT NULL,
+			source      TEXT    NOT NULL,
+			outcome     TEXT    NOT NULL,
+			detail      TEXT,
+			recheck_seg DOUBLE PRECISION
+		) ON COMMIT DROP`)
+	if err != nil {
+		return err
+	}
+	err = copiar(ctx, tx, "resultados_lote", []string{"orden", "dni", "source", "outcome", "detail", "recheck_seg"}, valores)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO lookup_outcomes (dni, source, outcome, detail, checked_at, recheck_after)
+		SELECT DISTINCT ON (dni, source)
+		       dni, source, outcome, detail, now(), now() + make_interval(secs => recheck_seg)
+		FROM resultados_lote
+		ORDER BY dni, source, orden DESC
+		ON CONFLICT (dni, source) DO UPDATE
+		SET outcome = EXCLUDED.outcome, detail = EXCLUDED.detail,
+		    checked_at = EXCLUDED.checked_at, recheck_after = EXCLUDED.recheck_after`)
+	return err
+}
+
+// copiar carga valores en tabla con COPY
+func copiar(ctx context.Context, tx *sql.Tx, tabla string, columnas []string, valores [][]any) error {
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn(tabla, columnas...))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, fila := range valores {
+		if _, err := stmt.ExecContext(ctx, fila...); err != nil {
+			return err
+		}
+	}
+	_, err = stmt.ExecContext(ctx)
+	return err
+}
+
+func nulo(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
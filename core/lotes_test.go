@@ -0,0 +1,93 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// escrituraDigito arma la escritura de reniec para el dígito que las reglas
+// sacan de la página guardada archivo
+func escrituraDigito(t *testing.T, archivo string) Escritura {
+	t.Helper()
+	cuerpo, err := os.ReadFile(filepath.Join(dirCasosReglas, archivo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	datos, err := ReglasDefecto()[NombreEldniDigito].ExtraerCampos(string(cuerpo), "45678912")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Escritura{
+		Persona: Persona{DNI: datos.DNI, CodigoVerif: datos.CodigoVerif, Extraccion: datos.Extraccion},
+		Fuente:  NombreEldniDigito,
+	}
+}
+
+// TestLoteDigitoRegex comprueba que un dígito que solo encontró una regla
+// regex queda en revisión y no se escribe
+func TestLoteDigitoRegex(t *testing.T) {
+	filas, apartados, _ := prepararLote([]Escritura{escrituraDigito(t, "eldni_digito_texto.html")})
+	for _, f := range filas {
+		if f.CodigoVerif != "" {
+			t.Errorf("el código %q de un respaldo regex se escribiría", f.CodigoVerif)
+		}
+	}
+	if len(apartados) != 1 {
+		t.Fatalf("%d conflictos, se esperaba 1", len(apartados))
+	}
+	c := apartados[0]
+	if c.Campo != CampoCodigoVerif || c.Motivo != MotivoRespaldo ||
+		len(c.Candidatos) != 1 || c.Candidatos[0].Valor != "9" || c.Candidatos[0].Metodo != EstrategiaRegex {
+		t.Errorf("conflicto inesperado: %+v", c)
+	}
+
+	filas, apartados, _ = prepararLote([]Escritura{escrituraDigito(t, "eldni_digito_mark.html")})
+	if len(apartados) != 0 || len(filas) != 1 || filas[0].CodigoVerif == "" {
+		t.Errorf("el código del <mark> debe escribirse: filas %+v, conflictos %+v", filas, apartados)
+	}
+}
+
+// TestLoteFechas comprueba que una fecha ilegible no se escribe, que su
+// consulta queda registrada como respuesta ilegible y que el resto del lote
+// sigue adelante con la fecha en formato SQL
+func TestLoteFechas(t *testing.T) {
+	lote := []Escritura{
+		{Persona: Persona{DNI: "45678912", FechaNacimiento: "15/03/1980"}, Fuente: NombreDniperu},
+		{Persona: Persona{DNI: "45678913", FechaNacimiento: "1980-03-15"}, Fuente: NombreDniperu},
+		{Persona: Persona{DNI: "45678914"}, Fuente: NombreDniperu, Error: ErrNotFound},
+	}
+	filas, _, errores := prepararLote(lote)
+
+	if len(filas) != 1 || filas[0].DNI != "45678912" || filas[0].fechaSQL != "1980-03-15" {
+		t.Fatalf("filas inesperadas: %+v", filas)
+	}
+	if errores[0] != nil || errores[2] != nil || !errors.Is(errores[1], ErrParse) {
+		t.Errorf("errores inesperados: %v", errores)
+	}
+
+	resultados := conErrores(lote, errores)
+	for i, esperado := range []string{ResultadoEncontrado, ResultadoErrorParseo, ResultadoNoEncontrado} {
+		if r := ClasificarResultado(resultados[i].Error); r != esperado {
+			t.Errorf("%s: resultado %q, se esperaba %q", lote[i].Persona.DNI, r, esperado)
+		}
+	}
+	if lote[1].Error != nil {
+		t.Errorf("conErrores modificó el lote")
+	}
+
+	cambios := filas[0].cambios()
+	if len(cambios) != 1 || cambios[0].Campo != CampoFechaNacimiento || cambios[0].Valor.String != "1980-03-15" ||
+		!cambios[0].SoloVacio || cambios[0].Confianza != ConfianzaFuente(NombreDniperu) {
+		t.Errorf("cambios inesperados: %+v", cambios)
+	}
+}
+
+func TestPorCopia(t *testing.T) {
+	for n, esperado := range map[int]bool{0: false, 1: false, UmbralCopia - 1: false, UmbralCopia: true, 500: true} {
+		if porCopia(n) != esperado {
+			t.Errorf("porCopia(%d) = %v", n, !esperado)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Métricas de Prometheus. Se registran siempre; solo se exponen si el
+// programa arranca ServirMetricas.
+var (
+	metricaConsultas = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "personas_consultas_total",
+		Help: "Consultas a cada fuente por resultado (found, not_found, ...), contando los reintentos como una.",
+	}, []string{"source", "outcome"})
+
+	metricaIntentos = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "personas_intentos_total",
+		Help: "Intentos hechos a cada fuente, reintentos incluidos.",
+	}, []string{"source"})
+
+	metricaDuracionConsulta = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "personas_consulta_duracion_segundos",
+		Help:    "Duración de cada consulta con sus reintentos.",
+		Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"source"})
+
+	metricaExtracciones = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "personas_extracciones_total",
+		Help: "Campos extraídos de cada fuente según la estrategia que los encontró (input, tabla, regex, ...).",
+	}, []string{"source", "field", "strategy"})
+
+	metricaEsperaLimite = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "personas_limite_espera_segundos_total",
+		Help: "Tiempo que los workers pasaron esperando turno en el limitador de cada host.",
+	}, []string{"host"})
+
+	metricaFrenos = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "personas_limite_frenos_total",
+		Help: "Veces que un host pidió bajar el ritmo (429, 503 o aviso en el cuerpo).",
+	}, []string{"host"})
+
+	metricaRenovaciones = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "personas_renovaciones_token_total",
+		Help: "Tokens CSRF o nonces pedidos por fuente, según hayan salido bien (ok) o no (error).",
+	}, []string{"source", "outcome"})
+
+	metricaEscrituraBD = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "personas_bd_escritura_duracion_segundos",
+		Help:    "Duración de las escrituras en PostgreSQL por operación.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	metricaCola = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "personas_cola_trabajos",
+		Help: "Jobs de enrichment_jobs por estado.",
+	}, []string{"status"})
+)
+
+// IntervaloMetricasCola es cada cuánto se cuenta la cola para la métrica
+const IntervaloMetricasCola = 15 * time.Second
+
+func registrarConsulta(fuente string, intentos int, d time.Duration, err error) {
+	resultado := ClasificarResultado(err)
+	if resultado == "" {
+		return
+	}
+	metricaConsultas.WithLabelValues(fuente, resultado).Inc()
+	metricaIntentos.WithLabelValues(fuente).Add(float64(intentos))
+	metricaDuracionConsulta.WithLabelValues(fuente).Observe(d.Seconds())
+}
+
+func registrarRenovacion(fuente string, err error) {
+	resultado := "ok"
+	if err != nil {
+		resultado = "error"
+	}
+	metricaRenovaciones.WithLabelValues(fuente, resultado).Inc()
+}
+
+// medirEscritura observa la duración de una escritura; se usa como
+// defer medirEscritura("operacion", time.Now())
+func medirEscritura(operacion string, inicio time.Time) {
+	metricaEscrituraBD.WithLabelValues(operacion).Observe(time.Since(inicio).Seconds())
+}
+
+// ServirMetricas expone /metrics en addr hasta que se cancele ctx
+func ServirMetricas(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	srv := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		cerrar, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(cerrar)
+	}()
+	go func() {
+		slog.Info("métricas disponibles", "url", "http://"+addr+"/metrics")
+		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("no se pudo servir métricas", "addr", addr, "error", err)
+		}
+	}()
+}
+
+// VigilarCola actualiza la métrica de la cola cada IntervaloMetricasCola
+// hasta que se cancele ctx
+func VigilarCola(ctx context.Context, db *sql.DB) {
+	for {
+		conteo, err := ContarTrabajos(ctx, db)
+		if err == nil {
+			for _, estado := range []string{EstadoPendiente, EstadoEnCurso, EstadoHecho, EstadoFallido} {
+				metricaCola.WithLabelValues(estado).Set(float64(conteo[estado]))
+			}
+		}
+		if Dormir(ctx, IntervaloMetricasCola) != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,7 @@
+-- Irreversible: revertirla borraría todos los datos de personas. Quien de
+-- verdad quiera empezar de cero debe borrar la tabla a mano.
+DO $$
+BEGIN
+    RAISE EXCEPTION '0001_personas no se revierte: borraría todos los datos de personas';
+END
+$$;
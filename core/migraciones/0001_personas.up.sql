@@ -0,0 +1,11 @@
+-- Tabla principal. En bases anteriores a las migraciones ya existe y se
+-- deja como está.
+CREATE TABLE IF NOT EXISTS personas (
+	id                 BIGSERIAL PRIMARY KEY,
+	dni                TEXT NOT NULL,
+	nombres            TEXT,
+	apellido_paterno   TEXT,
+	apellido_materno   TEXT,
+	fecha_nacimiento   DATE,
+	codigo_verificador TEXT
+);
@@ -0,0 +1,16 @@
+DROP INDEX IF EXISTS personas_sin_nombres;
+DROP INDEX IF EXISTS personas_sin_fecha;
+DROP INDEX IF EXISTS personas_sin_codigo;
+
+-- Solo se quita la restricción si la creó esta migración
+DO $$
+BEGIN
+	IF EXISTS (
+		SELECT 1 FROM pg_constraint
+		WHERE conname = 'personas_dni_key'
+		  AND conrelid = 'personas'::regclass
+		  AND obj_description(oid, 'pg_constraint') = '0002_indices_personas'
+	) THEN
+		ALTER TABLE personas DROP CONSTRAINT personas_dni_key;
+	END IF;
+END $$;
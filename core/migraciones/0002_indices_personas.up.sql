@@ -0,0 +1,24 @@
+-- Un DNI por fila; falla si ya hay duplicados y hay que limpiarlos antes.
+-- El comentario marca que la restricción es de esta migración, para que la
+-- bajada no quite una que ya estaba.
+DO $$
+BEGIN
+	IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'personas_dni_key') THEN
+		ALTER TABLE personas ADD CONSTRAINT personas_dni_key UNIQUE (dni);
+		COMMENT ON CONSTRAINT personas_dni_key ON personas IS '0002_indices_personas';
+	END IF;
+END $$;
+
+-- Índices parciales para las búsquedas de DNIs con campos vacíos, en el
+-- orden (id) en que se recorren
+CREATE INDEX IF NOT EXISTS personas_sin_codigo
+	ON personas (id) WHERE codigo_verificador IS NULL;
+
+CREATE INDEX IF NOT EXISTS personas_sin_fecha
+	ON personas (id) WHERE fecha_nacimiento IS NULL;
+
+CREATE INDEX IF NOT EXISTS personas_sin_nombres
+	ON personas (id)
+	WHERE nombres IS NULL OR nombres = ''
+	   OR apellido_paterno IS NULL OR apellido_paterno = ''
+	   OR apellido_materno IS NULL OR apellido_materno = '';
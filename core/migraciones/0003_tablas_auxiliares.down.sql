@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS lookup_outcomes;
+DROP TABLE IF EXISTS enrichment_jobs;
+DROP TABLE IF EXISTS personas_procedencia;
@@ -0,0 +1,35 @@
+-- Tablas del enriquecimiento que antes se creaban al arrancar cada programa
+CREATE TABLE IF NOT EXISTS personas_procedencia (
+	dni            TEXT        NOT NULL,
+	campo          TEXT        NOT NULL,
+	fuente         TEXT        NOT NULL,
+	actualizado_en TIMESTAMPTZ NOT NULL DEFAULT now(),
+	PRIMARY KEY (dni, campo)
+);
+
+CREATE TABLE IF NOT EXISTS enrichment_jobs (
+	dni             TEXT        NOT NULL,
+	source          TEXT        NOT NULL,
+	status          TEXT        NOT NULL DEFAULT 'pending',
+	attempts        INTEGER     NOT NULL DEFAULT 0,
+	last_error      TEXT,
+	next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	locked_by       TEXT,
+	locked_at       TIMESTAMPTZ,
+	created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+	updated_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+	PRIMARY KEY (dni, source)
+);
+
+CREATE INDEX IF NOT EXISTS enrichment_jobs_pendientes
+	ON enrichment_jobs (next_attempt_at) WHERE status = 'pending';
+
+CREATE TABLE IF NOT EXISTS lookup_outcomes (
+	dni           TEXT        NOT NULL,
+	source        TEXT        NOT NULL,
+	outcome       TEXT        NOT NULL,
+	detail        TEXT,
+	checked_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+	recheck_after TIMESTAMPTZ,
+	PRIMARY KEY (dni, source)
+);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS personas_history;
@@ -0,0 +1,15 @@
+-- Cada valor que se escribe en personas, con el que reemplazó, de qué fuente
+-- salió y en qué corrida, para poder explicarlo o revertirlo
+CREATE TABLE IF NOT EXISTS personas_history (
+	id         BIGSERIAL   PRIMARY KEY,
+	dni        TEXT        NOT NULL,
+	field      TEXT        NOT NULL,
+	old_value  TEXT,
+	new_value  TEXT,
+	source     TEXT        NOT NULL,
+	fetched_at TIMESTAMPTZ NOT NULL,
+	run_id     TEXT        NOT NULL,
+	written_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS personas_history_dni ON personas_history (dni, id);
@@ -0,0 +1,4 @@
+ALTER TABLE personas_procedencia DROP COLUMN IF EXISTS confianza;
+ALTER TABLE personas_history DROP COLUMN IF EXISTS confidence;
+DROP TABLE IF EXISTS personas_review_candidates;
+DROP TABLE IF EXISTS personas_review;
@@ -0,0 +1,27 @@
+-- Campos que no se escribieron porque las fuentes no coinciden, a la
+-- espera de que alguien elija el valor
+CREATE TABLE IF NOT EXISTS personas_review (
+	id         BIGSERIAL   PRIMARY KEY,
+	dni        TEXT        NOT NULL,
+	field      TEXT        NOT NULL,
+	reason     TEXT        NOT NULL,
+	status     TEXT        NOT NULL DEFAULT 'pending',
+	run_id     TEXT        NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Un solo pendiente por campo: si vuelve a discrepar se suman candidatos
+CREATE UNIQUE INDEX IF NOT EXISTS personas_review_pendiente
+	ON personas_review (dni, field) WHERE status = 'pending';
+
+CREATE TABLE IF NOT EXISTS personas_review_candidates (
+	review_id BIGINT NOT NULL REFERENCES personas_review (id) ON DELETE CASCADE,
+	source    TEXT   NOT NULL,
+	value     TEXT   NOT NULL,
+	method    TEXT,
+	PRIMARY KEY (review_id, source, value)
+);
+
+-- Confianza (0 a 1) del valor escrito
+ALTER TABLE personas_history ADD COLUMN IF NOT EXISTS confidence DOUBLE PRECISION;
+ALTER TABLE personas_procedencia ADD COLUMN IF NOT EXISTS confianza DOUBLE PRECISION;
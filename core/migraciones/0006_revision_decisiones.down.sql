@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS personas_review_estado;
+ALTER TABLE personas_history
+	DROP COLUMN IF EXISTS author,
+	DROP COLUMN IF EXISTS decision,
+	DROP COLUMN IF EXISTS review_id;
+ALTER TABLE personas_review
+	DROP COLUMN IF EXISTS resolved_value,
+	DROP COLUMN IF EXISTS resolved_at,
+	DROP COLUMN IF EXISTS resolved_by;
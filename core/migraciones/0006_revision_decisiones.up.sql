@@ -0,0 +1,13 @@
+-- Quién resolvió cada revisión, cuándo y con qué valor
+ALTER TABLE personas_review
+	ADD COLUMN IF NOT EXISTS resolved_by    TEXT,
+	ADD COLUMN IF NOT EXISTS resolved_at    TIMESTAMPTZ,
+	ADD COLUMN IF NOT EXISTS resolved_value TEXT;
+
+-- Las decisiones de revisión también quedan en el historial, con su autor
+ALTER TABLE personas_history
+	ADD COLUMN IF NOT EXISTS review_id BIGINT,
+	ADD COLUMN IF NOT EXISTS decision  TEXT,
+	ADD COLUMN IF NOT EXISTS author    TEXT;
+
+CREATE INDEX IF NOT EXISTS personas_review_estado ON personas_review (status, id);
@@ -0,0 +1,146 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"time"
+)
+
+// Reloj da la hora y hace las pausas de las fuentes; se reemplaza para que
+// las pruebas no esperen de verdad
+type Reloj interface {
+	Ahora() time.Time
+	Dormir(ctx context.Context, d time.Duration) error
+}
+
+type relojSistema struct{}
+
+func (relojSistema) Ahora() time.Time { return time.Now() }
+
+func (relojSistema) Dormir(ctx context.Context, d time.Duration) error { return Dormir(ctx, d) }
+
+// RelojSistema es el reloj real
+var RelojSistema Reloj = relojSistema{}
+
+// Opcion configura una fuente al crearla
+type Opcion func(*opciones)
+
+type opciones struct {
+	urls       URLs
+	base       string
+	cliente    *http.Client
+	transporte http.RoundTripper
+	timeout    time.Duration
+	limitador  *Limitador
+	reloj      Reloj
+	reglas     Reglas
+}
+
+// ConURLs apunta la fuente a las URLs de u que le correspondan; los campos
+// vacíos quedan con la URL del sitio real
+func ConURLs(u URLs) Opcion {
+	return func(o *opciones) { o.urls = u }
+}
+
+// ConURLBase cambia esquema y host de las URLs (p. ej. a un espejo o al
+// simulador) conservando la ruta de cada página
+func ConURLBase(base string) Opcion {
+	return func(o *opciones) { o.base = base }
+}
+
+// ConCliente usa una copia de c (con su jar de cookies) en lugar de un
+// cliente nuevo; las demás opciones de red se aplican sobre esa copia
+func ConCliente(c *http.Client) Opcion {
+	return func(o *opciones) { o.cliente = c }
+}
+
+// ConTransporte hace que las peticiones salgan por rt: un proxy de salida,
+// un transporte de grabaciones, etc.
+func ConTransporte(rt http.RoundTripper) Opcion {
+	return func(o *opciones) { o.transporte = rt }
+}
+
+func ConTimeout(d time.Duration) Opcion {
+	return func(o *opciones) { o.timeout = d }
+}
+
+// ConLimitador pasa todas las peticiones por l
+func ConLimitador(l *Limitador) Opcion {
+	return func(o *opciones) { o.limitador = l }
+}
+
+func ConReloj(r Reloj) Opcion {
+	return func(o *opciones) { o.reloj = r }
+}
+
+// ConReglas extrae los datos con r en lugar de las reglas embebidas
+func ConReglas(r Reglas) Opcion {
+	return func(o *opciones) { o.reglas = r }
+}
+
+func aplicarOpciones(opts []Opcion) *opciones {
+	o := &opciones{reloj: RelojSistema}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// reglasDe devuelve las reglas de fuente: las de ConReglas o las embebidas
+func (o *opciones) reglasDe(fuente string) *ReglasFuente {
+	if rf, ok := o.reglas[fuente]; ok {
+		return rf
+	}
+	return ReglasDefecto()[fuente]
+}
+
+// url elige entre la URL configurada y la del sitio real, y le aplica la base
+func (o *opciones) url(configurada, real string) string {
+	u := configurada
+	if u == "" {
+		u = real
+	}
+	if o.base == "" {
+		return u
+	}
+	base, err := url.Parse(o.base)
+	if err != nil {
+		return u
+	}
+	destino, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+	destino.Scheme, destino.Host = base.Scheme, base.Host
+	return destino.String()
+}
+
+// nuevoCliente arma el cliente según las opciones
+func (o *opciones) nuevoCliente() *http.Client {
+	var client *http.Client
+	if o.cliente != nil {
+		copia := *o.cliente
+		client = &copia
+	} else {
+		client = NuevoClienteHTTP(o.timeout)
+	}
+	if o.timeout > 0 {
+		client.Timeout = o.timeout
+	}
+	if o.transporte != nil {
+		client.Transport = o.transporte
+	}
+	if o.limitador != nil {
+		base := client.Transport
+		if base == nil {
+			base = http.DefaultTransport
+		}
+		client.Transport = &transporteLimitado{base: base, limitador: o.limitador}
+	}
+	if client.Jar == nil {
+		client.Jar, _ = cookiejar.New(nil)
+	}
+	return client
+}
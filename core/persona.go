@@ -0,0 +1,29 @@
+package core
+
+// Persona reúne los datos de un DNI que pueden venir de cualquier fuente.
+// Los campos vacíos son los que la fuente no pudo o no sabe completar.
+type Persona struct {
+	DNI             string `json:"dni"`
+	Nombres         string `json:"nombres"`
+	ApellidoPaterno string `json:"apellido_paterno"`
+	ApellidoMaterno string `json:"apellido_materno"`
+	FechaNacimiento string `json:"fecha_nacimiento"`
+	CodigoVerif     string `json:"codigo_verificador"`
+
+	// Extraccion dice con qué estrategia (EstrategiaInput, ...) salió cada
+	// campo de la respuesta de la fuente; no se guarda
+	Extraccion map[Campo]string `json:"-"`
+}
+
+// extraido asigna valor a c y anota la estrategia con que se obtuvo; un
+// valor vacío no cuenta como extraído
+func (p *Persona) extraido(c Campo, valor, estrategia string) {
+	if valor == "" {
+		return
+	}
+	p.Asignar(c, valor)
+	if p.Extraccion == nil {
+		p.Extraccion = make(map[Campo]string)
+	}
+	p.Extraccion[c] = estrategia
+}
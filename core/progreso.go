@@ -0,0 +1,275 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IntervaloProgreso es cada cuánto se escribe una línea de progreso en los
+// logs cuando stderr no es una terminal
+const IntervaloProgreso = 10 * time.Second
+
+// VentanaRitmo es el tramo reciente sobre el que se mide el ritmo actual
+const VentanaRitmo = time.Minute
+
+// EstadoWorker es lo que está haciendo un worker en este momento
+type EstadoWorker string
+
+const (
+	WorkerLibre              EstadoWorker = "libre"
+	WorkerEsperandoLimite    EstadoWorker = "esperando límite"
+	WorkerRenovandoToken     EstadoWorker = "renovando token"
+	WorkerConsultando        EstadoWorker = "consultando"
+	WorkerEsperandoReintento EstadoWorker = "esperando reintento"
+	WorkerTerminado          EstadoWorker = "terminado"
+)
+
+type estadoWorker struct {
+	estado  EstadoWorker
+	detalle string // fuente o host
+	desde   time.Time
+}
+
+// Progreso lleva la cuenta de los DNIs procesados y del estado de cada
+// worker. En una terminal dibuja un panel en stderr que se actualiza solo;
+// si no, escribe una línea en los logs cada IntervaloProgreso.
+type Progreso struct {
+	mu        sync.Mutex
+	total     int
+	hechos    int
+	errores   int
+	porMinuto float64 // ritmo máximo que permiten los límites; 0 sin límite
+	inicio    time.Time
+	recientes []time.Time // fin de cada DNI dentro de VentanaRitmo
+	workers   []estadoWorker
+	terminal  bool
+	parar     chan struct{}
+	listo     chan struct{}
+}
+
+// NuevoProgreso empieza a mostrar el avance de total DNIs repartidos entre
+// workers. porMinuto es el ritmo que permiten los límites configurados y
+// sirve para estimar el tiempo restante antes de tener ritmo medido.
+func NuevoProgreso(total, workers int, porMinuto float64) *Progreso {
+	p := &Progreso{
+		total:     total,
+		porMinuto: porMinuto,
+		inicio:    time.Now(),
+		workers:   make([]estadoWorker, workers),
+		terminal:  esTerminal(os.Stderr),
+		parar:     make(chan struct{}),
+		listo:     make(chan struct{}),
+	}
+	for i := range p.workers {
+		p.workers[i] = estadoWorker{estado: WorkerLibre, desde: p.inicio}
+	}
+
+	intervalo := IntervaloProgreso
+	if p.terminal {
+		intervalo = 250 * time.Millisecond
+		salida.mu.Lock()
+		salida.panel = p
+		salida.mu.Unlock()
+	}
+	go p.mostrar(intervalo)
+	return p
+}
+
+// RitmoLimite es el ritmo máximo, en DNIs por minuto, que permiten las
+// cuotas de fuentes: el de la más estricta, o 0 si ninguna tiene límite
+func RitmoLimite(cuotas map[string]Cuota, fuentes ...string) float64 {
+	ritmo := 0.0
+	for _, f := range fuentes {
+		if c := cuotas[f].PorMinuto; c > 0 && (ritmo == 0 || c < ritmo) {
+			ritmo = c
+		}
+	}
+	return ritmo
+}
+
+// Avanzar cuenta un DNI terminado, con o sin error
+func (p *Progreso) Avanzar(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.hechos++
+	if err != nil {
+		p.errores++
+	}
+	p.recientes = append(p.recientes, time.Now())
+}
+
+// Terminar deja el panel con el estado final y deja de actualizarlo
+func (p *Progreso) Terminar() {
+	select {
+	case <-p.parar:
+		return
+	default:
+	}
+	close(p.parar)
+	<-p.listo
+	if p.terminal {
+		salida.soltar(p)
+	}
+}
+
+// ConWorker devuelve un contexto con el que las fuentes, el limitador y la
+// política de reintentos informan el estado del worker id (desde 1)
+func (p *Progreso) ConWorker(ctx context.Context, id int) context.Context {
+	if id < 1 || id > len(p.workers) {
+		return ctx
+	}
+	return context.WithValue(ctx, claveWorker{}, refWorker{p, id - 1})
+}
+
+// WorkerTerminado marca que el worker id ya no va a tomar más DNIs
+func (p *Progreso) WorkerTerminado(id int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if id >= 1 && id <= len(p.workers) {
+		p.workers[id-1] = estadoWorker{estado: WorkerTerminado, desde: time.Now()}
+	}
+}
+
+type claveWorker struct{}
+
+type refWorker struct {
+	p *Progreso
+	i int
+}
+
+// marcarWorker pone al worker de ctx en estado y devuelve cómo volver al
+// estado anterior; un detalle vacío conserva el que había. Sin worker en
+// ctx no hace nada.
+func marcarWorker(ctx context.Context, estado EstadoWorker, detalle string) (restaurar func()) {
+	ref, ok := ctx.Value(claveWorker{}).(refWorker)
+	if !ok {
+		return func() {}
+	}
+	p := ref.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	anterior := p.workers[ref.i]
+	if detalle == "" {
+		detalle = anterior.detalle
+	}
+	p.workers[ref.i] = estadoWorker{estado: estado, detalle: detalle, desde: time.Now()}
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		anterior.desde = time.Now()
+		p.workers[ref.i] = anterior
+	}
+}
+
+func (p *Progreso) mostrar(intervalo time.Duration) {
+	defer close(p.listo)
+	ticker := time.NewTicker(intervalo)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.parar:
+			return
+		case <-ticker.C:
+			if p.terminal {
+				salida.redibujar()
+			} else {
+				p.registrar()
+			}
+		}
+	}
+}
+
+// resumen calcula el ritmo y el tiempo restante; eta es negativo mientras
+// no haya con qué estimarlo. Se llama con p.mu tomado.
+func (p *Progreso) resumen(ahora time.Time) (ritmo float64, eta time.Duration) {
+	// Ritmo de la última VentanaRitmo (o de lo que va si es menos)
+	i := 0
+	for i < len(p.recientes) && ahora.Sub(p.recientes[i]) > VentanaRitmo {
+		i++
+	}
+	p.recientes = p.recientes[i:]
+	if tramo := min(ahora.Sub(p.inicio), VentanaRitmo); tramo > time.Second && len(p.recientes) > 0 {
+		ritmo = float64(len(p.recientes)) / tramo.Minutes()
+	}
+
+	// Las primeras ráfagas van más rápido de lo que los límites sostienen
+	estimado := ritmo
+	if p.porMinuto > 0 && (estimado == 0 || estimado > p.porMinuto) {
+		estimado = p.porMinuto
+	}
+	switch restantes := p.total - p.hechos; {
+	case restantes <= 0:
+		eta = 0
+	case estimado > 0:
+		eta = time.Duration(float64(restantes) / estimado * float64(time.Minute)).Round(time.Second)
+	default:
+		eta = -1
+	}
+	return ritmo, eta
+}
+
+// panel arma el texto que se dibuja en la terminal, una línea por worker
+func (p *Progreso) panel() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ahora := time.Now()
+	ritmo, eta := p.resumen(ahora)
+
+	const ancho = 30
+	lleno := 0
+	if p.total > 0 {
+		lleno = min(p.hechos*ancho/p.total, ancho)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s%s] %d/%d  ✓ %d (%.0f%%)  ✗ %d (%.0f%%)  %.1f/min  ETA %s\n",
+		strings.Repeat("=", lleno), strings.Repeat(" ", ancho-lleno), p.hechos, p.total,
+		p.hechos-p.errores, porcentaje(p.hechos-p.errores, p.hechos), p.errores, porcentaje(p.errores, p.hechos),
+		ritmo, textoETA(eta))
+	for i, w := range p.workers {
+		fmt.Fprintf(&b, "  worker %-3d %-20s %-22s %s\n", i+1, w.estado, w.detalle, ahora.Sub(w.desde).Round(time.Second))
+	}
+	return b.String()
+}
+
+// registrar escribe el progreso como una línea de log
+func (p *Progreso) registrar() {
+	p.mu.Lock()
+	ritmo, eta := p.resumen(time.Now())
+	workers := make([]string, len(p.workers))
+	for i, w := range p.workers {
+		workers[i] = fmt.Sprintf("%d=%s", i+1, w.estado)
+		if w.detalle != "" {
+			workers[i] += "(" + w.detalle + ")"
+		}
+	}
+	attrs := []any{
+		"hechos", p.hechos, "total", p.total,
+		"exitosos", p.hechos - p.errores, "errores", p.errores,
+		"por_minuto", fmt.Sprintf("%.1f", ritmo), "eta", textoETA(eta),
+	}
+	p.mu.Unlock()
+
+	if len(workers) > 0 {
+		attrs = append(attrs, "workers", strings.Join(workers, " "))
+	}
+	slog.Info("progreso", attrs...)
+}
+
+func porcentaje(n, de int) float64 {
+	if de == 0 {
+		return 0
+	}
+	return float64(n) * 100 / float64(de)
+}
+
+func textoETA(eta time.Duration) string {
+	if eta < 0 {
+		return "?"
+	}
+	return eta.String()
+}
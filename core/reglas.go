@@ -0,0 +1,392 @@
+package core
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+	"gopkg.in/yaml.v3"
+)
+
+// Las reglas de extracción de cada fuente están en reglas/<fuente>.yaml. Las
+// embebidas se usan salvo que la configuración indique un directorio con
+// otra versión del archivo de esa fuente.
+//
+//go:embed reglas/*.yaml
+var archivosReglas embed.FS
+
+// OrigenEmbebido es el Origen de las reglas que vienen con el programa
+const OrigenEmbebido = "embebidas"
+
+// requeridas son, por fuente, los campos que sus reglas deben poder extraer
+var requeridas = map[string][]Campo{
+	NombreEldniDatos:  CamposNombre,
+	NombreEldniDigito: {CampoCodigoVerif},
+	NombreDniperu:     {CampoFechaNacimiento},
+}
+
+// Procesamientos que una regla puede aplicar al valor, tras recortar los
+// espacios de los extremos
+var procesamientos = map[string]func(string) string{
+	"mayusculas": strings.ToUpper,
+	"espacios":   func(s string) string { return strings.Join(strings.Fields(s), " ") },
+}
+
+// Reglas son las reglas de extracción de cada fuente, por nombre
+type Reglas map[string]*ReglasFuente
+
+// ReglasFuente es el contenido de reglas/<fuente>.yaml
+type ReglasFuente struct {
+	Fuente  string            `yaml:"fuente"`
+	Version int               `yaml:"version"`
+	Token   []Regla           `yaml:"token"` // token CSRF o nonce de la sesión
+	Campos  map[Campo][]Regla `yaml:"campos"`
+	Origen  string            `yaml:"-"` // OrigenEmbebido o la ruta del archivo
+}
+
+// Regla es una forma de encontrar un valor en una respuesta. Con Selector
+// se toma el atributo (o el texto) de cada elemento, o solo del número
+// Indice; con JSON, el valor de esa ruta con puntos; sin ninguno, el cuerpo
+// entero. Regex, si está, se aplica a eso y se queda con el primer grupo.
+// Gana el primer valor no vacío que cumpla Formato.
+type Regla struct {
+	Estrategia string   `yaml:"estrategia"` // EstrategiaInput, EstrategiaRegex, ...
+	Selector   string   `yaml:"selector"`
+	Atributo   string   `yaml:"atributo"`
+	Indice     *int     `yaml:"indice"`
+	Contiene   string   `yaml:"contiene"` // solo elementos cuyo texto contenga esto
+	JSON       string   `yaml:"json"`
+	Regex      string   `yaml:"regex"`
+	Procesar   []string `yaml:"procesar"`
+	Formato    string   `yaml:"formato"`
+
+	selector cascadia.Selector
+	regex    *regexp.Regexp
+	formato  *regexp.Regexp
+}
+
+var (
+	reglasDefecto     Reglas
+	reglasDefectoOnce sync.Once
+)
+
+// ReglasDefecto devuelve las reglas embebidas. Tienen que ser válidas: si
+// no, el programa no arranca.
+func ReglasDefecto() Reglas {
+	reglasDefectoOnce.Do(func() {
+		var err error
+		if reglasDefecto, err = CargarReglas(""); err != nil {
+			panic(fmt.Sprintf("reglas de extracción embebidas: %v", err))
+		}
+	})
+	return reglasDefecto
+}
+
+// CargarReglas lee y valida las reglas embebidas y, si dir no está vacío,
+// las de dir/*.yaml, que reemplazan a las embebidas de la misma fuente
+func CargarReglas(dir string) (Reglas, error) {
+	reglas := make(Reglas)
+	embebidas, err := archivosReglas.ReadDir("reglas")
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range embebidas {
+		data, err := archivosReglas.ReadFile(path.Join("reglas", a.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if err := reglas.agregar(a.Name(), OrigenEmbebido, data); err != nil {
+			return nil, err
+		}
+	}
+
+	if dir == "" {
+		return reglas, nil
+	}
+	archivos, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	if len(archivos) == 0 {
+		return nil, fmt.Errorf("no hay reglas (*.yaml) en %s", dir)
+	}
+	for _, archivo := range archivos {
+		data, err := os.ReadFile(archivo)
+		if err != nil {
+			return nil, err
+		}
+		if err := reglas.agregar(filepath.Base(archivo), archivo, data); err != nil {
+			return nil, err
+		}
+	}
+	return reglas, nil
+}
+
+// Fuentes devuelve los nombres de las fuentes con reglas, ordenados
+func (r Reglas) Fuentes() []string {
+	fuentes := make([]string, 0, len(r))
+	for f := range r {
+		fuentes = append(fuentes, f)
+	}
+	sort.Strings(fuentes)
+	return fuentes
+}
+
+func (r Reglas) agregar(nombre, origen string, data []byte) error {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var rf ReglasFuente
+	if err := dec.Decode(&rf); err != nil {
+		return fmt.Errorf("reglas %s: %v", origen, err)
+	}
+	rf.Origen = origen
+	if err := rf.validar(strings.TrimSuffix(nombre, ".yaml")); err != nil {
+		return fmt.Errorf("reglas %s: %v", origen, err)
+	}
+	r[rf.Fuente] = &rf
+	return nil
+}
+
+// validar comprueba el archivo y compila selectores y expresiones
+func (rf *ReglasFuente) validar(nombre string) error {
+	campos, ok := requeridas[rf.Fuente]
+	switch {
+	case !ok:
+		return fmt.Errorf("fuente desconocida %q", rf.Fuente)
+	case rf.Fuente != nombre:
+		return fmt.Errorf("el archivo de %s debe llamarse %s.yaml", rf.Fuente, rf.Fuente)
+	case rf.Version < 1:
+		return fmt.Errorf("falta la versión (entero desde 1)")
+	case len(rf.Token) == 0:
+		return fmt.Errorf("faltan las reglas del token")
+	}
+	for _, c := range campos {
+		if len(rf.Campos[c]) == 0 {
+			return fmt.Errorf("faltan las reglas de %s", c)
+		}
+	}
+
+	for i := range rf.Token {
+		if err := rf.Token[i].compilar(); err != nil {
+			return fmt.Errorf("token, regla %d: %v", i+1, err)
+		}
+	}
+	for c, reglas := range rf.Campos {
+		if !esCampo(c) {
+			return fmt.Errorf("campo desconocido %q", c)
+		}
+		for i := range reglas {
+			if err := reglas[i].compilar(); err != nil {
+				return fmt.Errorf("%s, regla %d: %v", c, i+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Regla) compilar() error {
+	switch r.Estrategia {
+	case EstrategiaJSON, EstrategiaInput, EstrategiaMark, EstrategiaTabla, EstrategiaClase, EstrategiaRegex:
+	default:
+		return fmt.Errorf("estrategia desconocida %q", r.Estrategia)
+	}
+	if r.Selector != "" && r.JSON != "" {
+		return fmt.Errorf("selector y json no se pueden combinar")
+	}
+	if r.Selector == "" && (r.Atributo != "" || r.Indice != nil || r.Contiene != "") {
+		return fmt.Errorf("atributo, indice y contiene necesitan un selector")
+	}
+	if r.Indice != nil && *r.Indice < 0 {
+		return fmt.Errorf("indice negativo")
+	}
+	if r.Selector == "" && r.JSON == "" && r.Regex == "" {
+		return fmt.Errorf("hace falta selector, json o regex")
+	}
+	for _, p := range r.Procesar {
+		if _, ok := procesamientos[p]; !ok {
+			return fmt.Errorf("procesamiento desconocido %q", p)
+		}
+	}
+
+	var err error
+	if r.Selector != "" {
+		if r.selector, err = cascadia.Compile(r.Selector); err != nil {
+			return fmt.Errorf("selector %q: %v", r.Selector, err)
+		}
+	}
+	if r.Regex != "" {
+		if r.regex, err = regexp.Compile(r.Regex); err != nil {
+			return fmt.Errorf("regex: %v", err)
+		}
+	}
+	if r.Formato != "" {
+		if r.formato, err = regexp.Compile(r.Formato); err != nil {
+			return fmt.Errorf("formato: %v", err)
+		}
+	}
+	return nil
+}
+
+// respuesta es el cuerpo de una página o de una respuesta JSON, que se
+// interpreta solo si alguna regla lo necesita
+type respuesta struct {
+	crudo string
+
+	doc     *goquery.Document
+	errDoc  error
+	json    any
+	errJSON error
+	leido   bool
+	leidoJS bool
+}
+
+func nuevaRespuesta(cuerpo string) *respuesta { return &respuesta{crudo: cuerpo} }
+
+func (r *respuesta) documento() (*goquery.Document, error) {
+	if !r.leido {
+		r.doc, r.errDoc = goquery.NewDocumentFromReader(strings.NewReader(r.crudo))
+		r.leido = true
+	}
+	return r.doc, r.errDoc
+}
+
+func (r *respuesta) datosJSON() (any, error) {
+	if !r.leidoJS {
+		r.errJSON = json.Unmarshal([]byte(r.crudo), &r.json)
+		r.leidoJS = true
+	}
+	return r.json, r.errJSON
+}
+
+// ExtraerToken aplica las reglas del token a cuerpo; devuelve "" si ninguna
+// lo encuentra
+func (rf *ReglasFuente) ExtraerToken(cuerpo string) (string, error) {
+	valor, _, err := extraerConReglas(nuevaRespuesta(cuerpo), rf.Token)
+	return valor, err
+}
+
+// ExtraerCampos aplica las reglas de cada campo a cuerpo y anota en
+// Extraccion la estrategia que encontró cada uno
+func (rf *ReglasFuente) ExtraerCampos(cuerpo, dni string) (*Persona, error) {
+	return rf.extraerCampos(nuevaRespuesta(cuerpo), dni)
+}
+
+func (rf *ReglasFuente) extraerCampos(r *respuesta, dni string) (*Persona, error) {
+	p := &Persona{DNI: dni}
+	for _, c := range Campos {
+		valor, estrategia, err := extraerConReglas(r, rf.Campos[c])
+		if err != nil {
+			return nil, err
+		}
+		p.extraido(c, valor, estrategia)
+	}
+	return p, nil
+}
+
+// extraerConReglas prueba reglas en orden y devuelve el primer valor válido
+// y la estrategia de la regla que lo dio
+func extraerConReglas(r *respuesta, reglas []Regla) (string, string, error) {
+	for i := range reglas {
+		valores, err := reglas[i].candidatos(r)
+		if err != nil {
+			return "", "", err
+		}
+		for _, v := range valores {
+			if v, ok := reglas[i].procesar(v); ok {
+				return v, reglas[i].Estrategia, nil
+			}
+		}
+	}
+	return "", "", nil
+}
+
+// candidatos son los textos a los que se aplica la regla, en orden
+func (rg *Regla) candidatos(r *respuesta) ([]string, error) {
+	switch {
+	case rg.JSON != "":
+		datos, err := r.datosJSON()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrParse, err)
+		}
+		if v, ok := valorJSON(datos, rg.JSON); ok {
+			return []string{v}, nil
+		}
+		return nil, nil
+
+	case rg.Selector != "":
+		doc, err := r.documento()
+		if err != nil {
+			return nil, err
+		}
+		sel := doc.FindMatcher(rg.selector)
+		if rg.Indice != nil {
+			sel = sel.Eq(*rg.Indice)
+		}
+		var valores []string
+		sel.Each(func(_ int, s *goquery.Selection) {
+			if rg.Contiene != "" && !strings.Contains(s.Text(), rg.Contiene) {
+				return
+			}
+			if rg.Atributo != "" {
+				valores = append(valores, s.AttrOr(rg.Atributo, ""))
+			} else {
+				valores = append(valores, s.Text())
+			}
+		})
+		return valores, nil
+	}
+	return []string{r.crudo}, nil
+}
+
+// procesar aplica regex, los procesamientos y el formato a v
+func (rg *Regla) procesar(v string) (string, bool) {
+	if rg.regex != nil {
+		m := rg.regex.FindStringSubmatch(v)
+		switch {
+		case m == nil:
+			return "", false
+		case len(m) > 1:
+			v = m[1]
+		default:
+			v = m[0]
+		}
+	}
+	v = strings.TrimSpace(v)
+	for _, p := range rg.Procesar {
+		v = procesamientos[p](v)
+	}
+	if v == "" || (rg.formato != nil && !rg.formato.MatchString(v)) {
+		return "", false
+	}
+	return v, true
+}
+
+// valorJSON sigue ruta (claves separadas por puntos) dentro de datos
+func valorJSON(datos any, ruta string) (string, bool) {
+	for _, clave := range strings.Split(ruta, ".") {
+		objeto, ok := datos.(map[string]any)
+		if !ok {
+			return "", false
+		}
+		if datos, ok = objeto[clave]; !ok {
+			return "", false
+		}
+	}
+	switch v := datos.(type) {
+	case string:
+		return v, true
+	case float64, bool:
+		return fmt.Sprint(v), true
+	}
+	return "", false
+}
@@ -0,0 +1,82 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ArchivoCasosReglas lista, dentro del directorio de páginas guardadas, qué
+// deben extraer las reglas de cada una
+const ArchivoCasosReglas = "casos.json"
+
+// CasoReglas es una página guardada de Fuente y lo que sus reglas deben
+// sacar de ella: el token o los campos, con la estrategia de cada uno. Los
+// campos que no están en Esperado deben salir vacíos.
+type CasoReglas struct {
+	Fuente      string            `json:"fuente"`
+	Archivo     string            `json:"archivo"`
+	Token       string            `json:"token,omitempty"`
+	Esperado    map[string]string `json:"esperado,omitempty"`
+	Estrategias map[string]string `json:"estrategias,omitempty"`
+	Nota        string            `json:"nota,omitempty"`
+}
+
+// LeerCasosReglas lee dir/casos.json
+func LeerCasosReglas(dir string) ([]CasoReglas, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ArchivoCasosReglas))
+	if err != nil {
+		return nil, err
+	}
+	var casos []CasoReglas
+	if err := json.Unmarshal(data, &casos); err != nil {
+		return nil, fmt.Errorf("%s: %v", ArchivoCasosReglas, err)
+	}
+	return casos, nil
+}
+
+// ProbarCaso aplica las reglas de la fuente de c a su página, leída de dir,
+// y devuelve en qué difiere lo extraído de lo esperado; vacío si coincide
+func (r Reglas) ProbarCaso(dir string, c CasoReglas) ([]string, error) {
+	rf, ok := r[c.Fuente]
+	if !ok {
+		return nil, fmt.Errorf("no hay reglas para la fuente %q", c.Fuente)
+	}
+	cuerpo, err := os.ReadFile(filepath.Join(dir, c.Archivo))
+	if err != nil {
+		return nil, err
+	}
+	resp := nuevaRespuesta(string(cuerpo))
+
+	var diferencias []string
+	if c.Token != "" {
+		token, _, err := extraerConReglas(resp, rf.Token)
+		if err != nil {
+			return nil, err
+		}
+		if token != c.Token {
+			diferencias = append(diferencias, fmt.Sprintf("token=%q, se esperaba %q", token, c.Token))
+		}
+		if len(c.Esperado) == 0 {
+			// Página del formulario: no tiene datos que comparar
+			return diferencias, nil
+		}
+	}
+
+	p, err := rf.extraerCampos(resp, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, campo := range Campos {
+		obtenido, esperado := p.Valor(campo), c.Esperado[string(campo)]
+		if obtenido != esperado {
+			diferencias = append(diferencias, fmt.Sprintf("%s=%q, se esperaba %q", campo, obtenido, esperado))
+			continue
+		}
+		if estrategia, ok := c.Estrategias[string(campo)]; ok && p.Extraccion[campo] != estrategia {
+			diferencias = append(diferencias, fmt.Sprintf("%s salió con %s, se esperaba %s", campo, p.Extraccion[campo], estrategia))
+		}
+	}
+	return diferencias, nil
+}
@@ -0,0 +1,30 @@
+package core
+
+import "testing"
+
+const dirCasosReglas = "testdata/reglas"
+
+// TestReglasEmbebidas prueba las reglas que vienen con el programa contra
+// cada página guardada de testdata/reglas
+func TestReglasEmbebidas(t *testing.T) {
+	casos, err := LeerCasosReglas(dirCasosReglas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(casos) == 0 {
+		t.Fatalf("no hay casos en %s", dirCasosReglas)
+	}
+
+	reglas := ReglasDefecto()
+	for _, c := range casos {
+		t.Run(c.Fuente+"/"+c.Archivo, func(t *testing.T) {
+			diferencias, err := reglas.ProbarCaso(dirCasosReglas, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, d := range diferencias {
+				t.Error(d)
+			}
+		})
+	}
+}
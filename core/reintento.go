@@ -0,0 +1,142 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// PoliticaReintento define cómo se reintenta una consulta: hasta MaxIntentos
+// intentos con espera exponencial y jitter entre EsperaBase y EsperaMax, sin
+// pasar de Plazo en total. Solo se reintentan los errores para los que
+// Reintentable devuelve true (EsReintentable si es nil). Las esperas y el
+// plazo se miden con Reloj, o con el reloj del sistema si es nil.
+type PoliticaReintento struct {
+	MaxIntentos  int              `yaml:"max_intentos"`
+	EsperaBase   time.Duration    `yaml:"espera_base"`
+	EsperaMax    time.Duration    `yaml:"espera_max"`
+	Plazo        time.Duration    `yaml:"plazo"` // 0 es sin plazo
+	Reintentable func(error) bool `yaml:"-"`
+	Reloj        Reloj            `yaml:"-"`
+}
+
+func PoliticaReintentoDefecto() PoliticaReintento {
+	return PoliticaReintento{
+		MaxIntentos: 3,
+		EsperaBase:  2 * time.Second,
+		EsperaMax:   30 * time.Second,
+		Plazo:       2 * time.Minute,
+	}
+}
+
+// EsReintentable separa los errores pasajeros (rate limit, red, token
+// vencido o 419, errores 5xx) de los que se repetirían igual en otro intento
+func EsReintentable(err error) bool {
+	switch {
+	case err == nil,
+		errors.Is(err, context.Canceled),
+		errors.Is(err, ErrNotFound),
+		errors.Is(err, ErrInvalidDNI),
+		errors.Is(err, ErrAccessDenied),
+		errors.Is(err, ErrParse):
+		return false
+	case errors.Is(err, ErrRateLimited), errors.Is(err, ErrTokenMissing):
+		return true
+	}
+
+	var errHTTP *ErrorHTTP
+	if errors.As(err, &errHTTP) {
+		return errHTTP.Status >= 500 || errHTTP.Status == 419
+	}
+	// El resto son errores de red o timeouts del cliente
+	return true
+}
+
+// Espera devuelve la pausa antes del intento siguiente a intento: crece al
+// doble en cada intento hasta EsperaMax y se elige al azar en su mitad
+// superior para que los workers no reintenten todos a la vez
+func (p PoliticaReintento) Espera(intento int) time.Duration {
+	espera := p.EsperaBase
+	for i := 1; i < intento && espera < p.EsperaMax; i++ {
+		espera *= 2
+	}
+	if espera > p.EsperaMax {
+		espera = p.EsperaMax
+	}
+	if espera <= 0 {
+		return 0
+	}
+	return espera/2 + time.Duration(rand.Int63n(int64(espera/2)+1))
+}
+
+// Ejecutar llama a fn hasta que tenga éxito, devuelva un error no
+// reintentable o se agoten los intentos o el plazo. Devuelve cuántos
+// intentos se hicieron y el último error. Además de no esperar más allá del
+// plazo, el contexto de fn vence a los Plazo para cortar un intento colgado.
+func (p PoliticaReintento) Ejecutar(ctx context.Context, fn func(ctx context.Context) error) (int, error) {
+	reloj := p.Reloj
+	if reloj == nil {
+		reloj = RelojSistema
+	}
+	var fin time.Time
+	if p.Plazo > 0 {
+		fin = reloj.Ahora().Add(p.Plazo)
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Plazo)
+		defer cancel()
+	}
+	reintentable := p.Reintentable
+	if reintentable == nil {
+		reintentable = EsReintentable
+	}
+	max := p.MaxIntentos
+	if max < 1 {
+		max = 1
+	}
+
+	var err error
+	for intento := 1; ; intento++ {
+		if err = fn(ctx); err == nil {
+			return intento, nil
+		}
+		if intento >= max || !reintentable(err) {
+			return intento, err
+		}
+
+		espera := p.Espera(intento)
+		var limite *RateLimitError
+		if errors.As(err, &limite) && limite.RetryAfter > espera {
+			espera = limite.RetryAfter
+		}
+		if !fin.IsZero() && reloj.Ahora().Add(espera).After(fin) {
+			return intento, fmt.Errorf("%w (tras %d intentos: %v)", context.DeadlineExceeded, intento, err)
+		}
+		restaurar := marcarWorker(ctx, WorkerEsperandoReintento, "")
+		errDormir := reloj.Dormir(ctx, espera)
+		restaurar()
+		if errDormir != nil {
+			return intento, fmt.Errorf("%w (tras %d intentos: %v)", errDormir, intento, err)
+		}
+	}
+}
+
+// LookupConReintentos consulta s aplicando la política y devuelve también
+// cuántos intentos hicieron falta
+func LookupConReintentos(ctx context.Context, s Source, dni string, p PoliticaReintento) (*Persona, int, error) {
+	var persona *Persona
+	defer marcarWorker(ctx, WorkerConsultando, s.Name())()
+	inicio := time.Now()
+	intentos, err := p.Ejecutar(ctx, func(ctx context.Context) error {
+		var err error
+		persona, err = s.Lookup(ctx, dni)
+		return err
+	})
+	registrarConsulta(s.Name(), intentos, time.Since(inicio), err)
+	if err != nil {
+		return nil, intentos, err
+	}
+	registrarExtraccion(s.Name(), persona)
+	return persona, intentos, nil
+}
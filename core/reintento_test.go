@@ -0,0 +1,111 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestEspera comprueba que la espera se duplica por intento hasta
+// EsperaMax y que el jitter la deja en su mitad superior
+func TestEspera(t *testing.T) {
+	p := PoliticaReintento{EsperaBase: time.Second, EsperaMax: 8 * time.Second}
+	casos := []struct {
+		politica PoliticaReintento
+		intento  int
+		techo    time.Duration
+	}{
+		{p, 1, time.Second},
+		{p, 2, 2 * time.Second},
+		{p, 3, 4 * time.Second},
+		{p, 4, 8 * time.Second},
+		{p, 5, 8 * time.Second},
+		{p, 40, 8 * time.Second}, // sin desbordar
+		{PoliticaReintento{EsperaBase: 10 * time.Second, EsperaMax: 3 * time.Second}, 1, 3 * time.Second},
+		{PoliticaReintento{}, 3, 0},
+	}
+	for _, c := range casos {
+		for i := 0; i < 100; i++ {
+			espera := c.politica.Espera(c.intento)
+			if espera < c.techo/2 || espera > c.techo {
+				t.Errorf("Espera(%d) con base %v y máximo %v = %v, fuera de [%v, %v]",
+					c.intento, c.politica.EsperaBase, c.politica.EsperaMax, espera, c.techo/2, c.techo)
+				break
+			}
+		}
+	}
+}
+
+// TestEjecutarRetryAfter comprueba que un Retry-After mayor que EsperaMax
+// manda sobre la espera exponencial, y cuándo se deja de reintentar
+func TestEjecutarRetryAfter(t *testing.T) {
+	casos := []struct {
+		motivo   string
+		errores  []error
+		intentos int
+		espera   time.Duration
+		falla    bool
+	}{
+		{
+			motivo:   "Retry-After mayor que el máximo",
+			errores:  []error{&RateLimitError{Status: http.StatusTooManyRequests, RetryAfter: 20 * time.Second}},
+			intentos: 2,
+			espera:   20 * time.Second,
+		},
+		{
+			motivo:   "Retry-After menor que la espera exponencial",
+			errores:  []error{&RateLimitError{Status: http.StatusTooManyRequests, RetryAfter: time.Millisecond}, &ErrorHTTP{Status: 502}},
+			intentos: 3,
+		},
+		{
+			motivo:   "error definitivo",
+			errores:  []error{ErrNotFound},
+			intentos: 1,
+			falla:    true,
+		},
+		{
+			motivo:   "se agotan los intentos",
+			errores:  []error{ErrRateLimited, ErrRateLimited, ErrRateLimited, ErrRateLimited},
+			intentos: 3,
+			falla:    true,
+		},
+	}
+	for _, c := range casos {
+		t.Run(c.motivo, func(t *testing.T) {
+			r := &relojFijo{ahora: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+			inicio := r.ahora
+			p := PoliticaReintento{MaxIntentos: 3, EsperaBase: time.Second, EsperaMax: 4 * time.Second, Plazo: time.Minute, Reloj: r}
+
+			llamadas := 0
+			intentos, err := p.Ejecutar(context.Background(), func(context.Context) error {
+				llamadas++
+				if llamadas <= len(c.errores) {
+					return c.errores[llamadas-1]
+				}
+				return nil
+			})
+			if intentos != c.intentos || llamadas != c.intentos || (err != nil) != c.falla {
+				t.Fatalf("%d intentos, %d llamadas, error %v", intentos, llamadas, err)
+			}
+			if c.falla && !errors.Is(err, c.errores[intentos-1]) {
+				t.Errorf("error %v, se esperaba el del último intento", err)
+			}
+			esperado := c.espera
+			if esperado == 0 {
+				// Solo la exponencial: entre la mitad y el total de 1s, 2s, ...
+				for i := 1; i < c.intentos; i++ {
+					esperado += min(time.Second<<(i-1), p.EsperaMax)
+				}
+				if d := r.ahora.Sub(inicio); d < esperado/2 || d > esperado {
+					t.Errorf("esperó %v, se esperaba entre %v y %v", d, esperado/2, esperado)
+				}
+				return
+			}
+			if d := r.ahora.Sub(inicio); d != esperado {
+				t.Errorf("esperó %v, se esperaba %v", d, esperado)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Resultados de una consulta que se guardan en lookup_outcomes
+const (
+	ResultadoEncontrado      = "found"
+	ResultadoNoEncontrado    = "not_found"
+	ResultadoAccesoDenegado  = "access_denied"
+	ResultadoErrorParseo     = "parse_failure"
+	ResultadoErrorTransporte = "transport_error"
+)
+
+// Reconsulta indica cuánto esperar antes de volver a consultar una fuente
+// por un DNI según el último resultado. Cero significa volver a intentar en
+// la próxima corrida.
+type Reconsulta struct {
+	NoEncontrado    time.Duration `yaml:"not_found"`
+	AccesoDenegado  time.Duration `yaml:"access_denied"`
+	ErrorParseo     time.Duration `yaml:"parse_failure"`
+	ErrorTransporte time.Duration `yaml:"transport_error"`
+}
+
+func ReconsultaDefecto() Reconsulta {
+	return Reconsulta{
+		NoEncontrado:    30 * 24 * time.Hour,
+		AccesoDenegado:  time.Hour,
+		ErrorParseo:     24 * time.Hour,
+		ErrorTransporte: 10 * time.Minute,
+	}
+}
+
+// Intervalo devuelve la espera para un resultado; los encontrados no se
+// reconsultan porque el campo ya quedó completo
+func (r Reconsulta) Intervalo(resultado string) time.Duration {
+	switch resultado {
+	case ResultadoNoEncontrado:
+		return r.NoEncontrado
+	case ResultadoAccesoDenegado:
+		return r.AccesoDenegado
+	case ResultadoErrorParseo:
+		return r.ErrorParseo
+	case ResultadoErrorTransporte:
+		return r.ErrorTransporte
+	}
+	return 0
+}
+
+// ClasificarResultado traduce el error de un Lookup a uno de los resultados.
+// Devuelve "" si la consulta se canceló y no hay nada que registrar.
+func ClasificarResultado(err error) string {
+	if err == nil {
+		return ResultadoEncontrado
+	}
+	if errors.Is(err, context.Canceled) {
+		return ""
+	}
+
+	switch {
+	case errors.Is(err, ErrNotFound), errors.Is(err, ErrInvalidDNI):
+		return ResultadoNoEncontrado
+	case errors.Is(err, ErrAccessDenied):
+		return ResultadoAccesoDenegado
+	case errors.Is(err, ErrParse), errors.Is(err, ErrTokenMissing):
+		return ResultadoErrorParseo
+	}
+	return ResultadoErrorTransporte
+}
+
+type ejecutor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// registrarResultado guarda el último resultado de consultar fuente por dni
+// y desde cuándo se puede volver a consultar
+func registrarResultado(ctx context.Context, ex ejecutor, dni, fuente string, err error, r Reconsulta) error {
+	resultado, detalle, reconsultar := valoresResultado(err, r)
+	if resultado == "" {
+		return nil
+	}
+
+	_, errBD := ex.ExecContext(ctx, `
+		INSERT INTO lookup_outcomes (dni, source, outcome, detail, checked_at, recheck_after)
+		VALUES ($1, $2, $3, $4, now(), now() + make_interval(secs => $5))
+		ON CONFLICT (dni, source) DO UPDATE
+		SET outcome = EXCLUDED.outcome, detail = EXCLUDED.detail,
+		    checked_at = EXCLUDED.checked_at, recheck_after = EXCLUDED.recheck_after`,
+		dni, fuente, resultado, detalle, reconsultar)
+	return errBD
+}
+
+// valoresResultado arma las columnas outcome, detail y la espera en segundos
+// hasta recheck_after; resultado vacío es que no hay nada que registrar
+func valoresResultado(err error, r Reconsulta) (resultado string, detalle sql.NullString, reconsultar sql.NullFloat64) {
+	resultado = ClasificarResultado(err)
+	if err != nil {
+		detalle = sql.NullString{String: err.Error(), Valid: true}
+	}
+	if resultado != "" && resultado != ResultadoEncontrado {
+		reconsultar = sql.NullFloat64{Float64: r.Intervalo(resultado).Seconds(), Valid: true}
+	}
+	return resultado, detalle, reconsultar
+}
+
+// sinReconsultaPendiente filtra, dentro de un WHERE sobre personas, los DNIs
+// cuyo último resultado con la fuente $1 todavía no permite reconsultar
+const sinReconsultaPendiente = `
+		NOT EXISTS (
+			SELECT 1 FROM lookup_outcomes o
+			WHERE o.dni = personas.dni AND o.source = $1 AND o.recheck_after > now()
+		)`
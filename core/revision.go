@@ -0,0 +1,247 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Estados de una revisión en personas_review
+const (
+	RevisionPendiente = "pending"
+	RevisionAceptada  = "accepted" // se escribió uno de los candidatos
+	RevisionEditada   = "edited"   // se escribió un valor que puso el revisor
+	RevisionRechazada = "rejected" // el campo quedó como estaba
+)
+
+// FuenteRevision es la fuente con la que queda en el historial un valor
+// escrito a mano por un revisor
+const FuenteRevision = "review"
+
+// Revision es un campo en personas_review junto con sus candidatos y el
+// valor que tiene ahora en personas
+type Revision struct {
+	ID         int64
+	DNI        string
+	Campo      Campo
+	Motivo     string
+	Estado     string
+	Corrida    string
+	CreadaEn   time.Time
+	Actual     sql.NullString
+	Candidatos []Candidato
+	// Huerfana indica que el DNI ya no está en personas: la revisión se
+	// lista, pero no se puede resolver
+	Huerfana bool
+}
+
+// RevisionesPendientes devuelve hasta limite revisiones pendientes, de la
+// más antigua a la más nueva, con las huérfanas marcadas
+func RevisionesPendientes(ctx context.Context, db *sql.DB, limite int) ([]Revision, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT r.id, r.dni, r.field, r.reason, r.status, r.run_id, r.created_at,
+		       `+valorCampo("r.field")+`, p.dni IS NULL
+		FROM personas_review r
+		LEFT JOIN personas p ON p.dni = r.dni
+		WHERE r.status = $1
+		ORDER BY r.id
+		LIMIT $2`, RevisionPendiente, limite)
+	if err != nil {
+		return nil, err
+	}
+	var revisiones []Revision
+	var ids []int64
+	for rows.Next() {
+		var r Revision
+		var campo string
+		if err := rows.Scan(&r.ID, &r.DNI, &campo, &r.Motivo, &r.Estado, &r.Corrida, &r.CreadaEn, &r.Actual, &r.Huerfana); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		r.Campo = Campo(campo)
+		revisiones = append(revisiones, r)
+		ids = append(ids, r.ID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return revisiones, nil
+	}
+
+	candidatos, err := leerCandidatos(ctx, db, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range revisiones {
+		revisiones[i].Candidatos = candidatos[revisiones[i].ID]
+	}
+	return revisiones, nil
+}
+
+// ObtenerRevision lee la revisión id con sus candidatos
+func ObtenerRevision(ctx context.Context, db *sql.DB, id int64) (*Revision, error) {
+	return leerRevision(ctx, db, id, false)
+}
+
+// AceptarRevision escribe el candidato n (desde 1, en el orden en que se
+// listan) de la revisión id
+func AceptarRevision(ctx context.Context, db *sql.DB, id int64, n int, autor string) (*Revision, error) {
+	return resolverRevision(ctx, db, id, autor, func(r *Revision) (cambio, error) {
+		if n < 1 || n > len(r.Candidatos) {
+			return cambio{}, fmt.Errorf("la revisión %d tiene %d candidatos", id, len(r.Candidatos))
+		}
+		candidato := r.Candidatos[n-1]
+		if candidato.Metodo == MetodoNombreCompleto {
+			return cambio{}, fmt.Errorf("el candidato %d es el nombre completo; usar edit con el valor del campo", n)
+		}
+		return cambio{Valor: nulo(candidato.Valor), Fuente: candidato.Fuente, Decision: RevisionAceptada}, nil
+	})
+}
+
+// EditarRevision escribe valor, puesto por el revisor, en el campo de la
+// revisión id
+func EditarRevision(ctx context.Context, db *sql.DB, id int64, valor, autor string) (*Revision, error) {
+	return resolverRevision(ctx, db, id, autor, func(r *Revision) (cambio, error) {
+		if valor == "" {
+			return cambio{}, fmt.Errorf("falta el valor")
+		}
+		return cambio{Valor: nulo(valor), Fuente: FuenteRevision, Decision: RevisionEditada}, nil
+	})
+}
+
+// RechazarRevision cierra la revisión id sin tocar el campo
+func RechazarRevision(ctx context.Context, db *sql.DB, id int64, autor string) (*Revision, error) {
+	return resolverRevision(ctx, db, id, autor, func(r *Revision) (cambio, error) {
+		return cambio{Valor: r.Actual, Fuente: FuenteRevision, Decision: RevisionRechazada}, nil
+	})
+}
+
+// resolverRevision cierra la revisión id con la decisión que arma decidir:
+// la anota en el historial con su autor y, si no es un rechazo, escribe el
+// valor. Falla si la revisión ya no está pendiente o es huérfana.
+func resolverRevision(ctx context.Context, db *sql.DB, id int64, autor string, decidir func(*Revision) (cambio, error)) (*Revision, error) {
+	if autor == "" {
+		return nil, fmt.Errorf("falta el autor de la decisión")
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	r, err := leerRevision(ctx, tx, id, true)
+	if err != nil {
+		return nil, err
+	}
+	if r.Estado != RevisionPendiente {
+		return nil, fmt.Errorf("la revisión %d ya está %s", id, r.Estado)
+	}
+	if r.Huerfana {
+		return nil, fmt.Errorf("la persona de la revisión %d ya no existe", id)
+	}
+
+	c, err := decidir(r)
+	if err != nil {
+		return nil, err
+	}
+	c.DNI, c.Campo, c.ObtenidoEn = r.DNI, r.Campo, time.Now()
+	c.Revision, c.Autor = r.ID, autor
+	if c.Decision != RevisionRechazada {
+		c.Confianza = ConfianzaCorroborada
+	}
+	if err := registrarCambios(ctx, tx, []cambio{c}); err != nil {
+		return nil, err
+	}
+	if c.Decision != RevisionRechazada {
+		if err := escribirCampo(ctx, tx, r.DNI, r.Campo, c.Valor); err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE personas_review
+		SET status = $1, resolved_by = $2, resolved_at = now(), resolved_value = $3
+		WHERE id = $4`, c.Decision, autor, c.Valor, id)
+	if err != nil {
+		return nil, err
+	}
+	r.Estado = c.Decision
+	r.Actual = c.Valor
+	return r, tx.Commit()
+}
+
+// leerRevision lee la revisión id; con bloquear, la fila de personas_review
+// y la de personas quedan bloqueadas hasta el final de la transacción. Si la
+// persona ya no existe la revisión queda marcada como huérfana.
+func leerRevision(ctx context.Context, db consultorFila, id int64, bloquear bool) (*Revision, error) {
+	bloqueo := ""
+	if bloquear {
+		bloqueo = " FOR UPDATE"
+	}
+
+	var r Revision
+	var campo string
+	err := db.QueryRowContext(ctx, `
+		SELECT id, dni, field, reason, status, run_id, created_at
+		FROM personas_review WHERE id = $1`+bloqueo, id).
+		Scan(&r.ID, &r.DNI, &campo, &r.Motivo, &r.Estado, &r.Corrida, &r.CreadaEn)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("no hay una revisión con id %d", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.Campo = Campo(campo)
+	if !esCampo(r.Campo) {
+		return nil, fmt.Errorf("campo desconocido %q en la revisión %d", campo, id)
+	}
+
+	err = db.QueryRowContext(ctx, `SELECT `+columnaTexto(r.Campo)+` FROM personas p WHERE p.dni = $1`+bloqueo, r.DNI).
+		Scan(&r.Actual)
+	r.Huerfana = err == sql.ErrNoRows
+	if err != nil && !r.Huerfana {
+		return nil, err
+	}
+
+	candidatos, err := leerCandidatos(ctx, db, []int64{id})
+	if err != nil {
+		return nil, err
+	}
+	r.Candidatos = candidatos[id]
+	return &r, nil
+}
+
+// leerCandidatos devuelve los candidatos de cada revisión de ids, en el
+// orden en que se listan
+func leerCandidatos(ctx context.Context, db consultor, ids []int64) (map[int64][]Candidato, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT review_id, source, value, COALESCE(method, '')
+		FROM personas_review_candidates
+		WHERE review_id = ANY($1)
+		ORDER BY review_id, source, value`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	candidatos := make(map[int64][]Candidato)
+	for rows.Next() {
+		var id int64
+		var c Candidato
+		if err := rows.Scan(&id, &c.Fuente, &c.Valor, &c.Metodo); err != nil {
+			return nil, err
+		}
+		candidatos[id] = append(candidatos[id], c)
+	}
+	return candidatos, rows.Err()
+}
+
+type consultorFila interface {
+	consultor
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
@@ -0,0 +1,338 @@
+// Package simulador imita eldni.com y dniperu.com en un servidor local para
+// correr los programas de punta a punta sin salir a internet.
+//
+// Reproduce el flujo de cada sitio: formulario con token CSRF (GET) y envío
+// del formulario (POST) en eldni.com, y página con el nonce de fecha_vars más
+// la acción buscar_fecha de admin-ajax.php en dniperu.com. Según la Config
+// también responde 429, -1 o 0, comprime con gzip y vence tokens.
+package simulador
+
+import (
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"personas/core"
+)
+
+// Rutas que imitan las de los sitios reales
+const (
+	RutaEldniDatos    = "/pe/buscar-datos-por-dni"
+	RutaEldniDigito   = "/pe/obtener-digito-verificador-del-dni"
+	RutaDniperuPagina = "/fecha-de-nacimiento-con-dni/"
+	RutaDniperuAjax   = "/wp-admin/admin-ajax.php"
+)
+
+// Config define cómo se comporta el simulador
+type Config struct {
+	// Personas conocidas por DNI; si es nil se inventa una persona fija para
+	// cualquier DNI que no esté en NoEncontrados ni en Denegados
+	Personas      map[string]core.Persona
+	NoEncontrados []string // eldni muestra la página vacía y dniperu responde 0
+	Denegados     []string // dniperu responde -1
+
+	LimiteCada int           // cada cuántas consultas de un sitio se responde 429; 0 nunca
+	RetryAfter time.Duration // Retry-After de los 429; 0 no lo envía
+	VidaToken  int           // consultas que acepta un token o nonce antes de vencer; 0 nunca vence
+	Gzip       bool          // comprime las respuestas si el cliente acepta gzip
+}
+
+// Simulador es el http.Handler de los dos sitios
+type Simulador struct {
+	cfg Config
+	mux *http.ServeMux
+
+	mu        sync.Mutex
+	tokens    map[string]int // token o nonce → consultas que le quedan (-1 sin límite)
+	consultas map[string]int // consultas recibidas por sitio
+}
+
+func Nuevo(cfg Config) *Simulador {
+	s := &Simulador{
+		cfg:       cfg,
+		mux:       http.NewServeMux(),
+		tokens:    make(map[string]int),
+		consultas: make(map[string]int),
+	}
+	s.mux.HandleFunc(RutaEldniDatos, s.eldniDatos)
+	s.mux.HandleFunc(RutaEldniDigito, s.eldniDigito)
+	s.mux.HandleFunc(RutaDniperuPagina, s.dniperuPagina)
+	s.mux.HandleFunc(RutaDniperuAjax, s.dniperuAjax)
+	return s
+}
+
+func (s *Simulador) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.cfg.Gzip && strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		w.Header().Set("Content-Encoding", "gzip")
+		w = respuestaGzip{ResponseWriter: w, gz: gz}
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// Iniciar levanta el simulador en un httptest.Server; cerrarlo al terminar
+func (s *Simulador) Iniciar() *httptest.Server {
+	return httptest.NewServer(s)
+}
+
+// URLs devuelve la configuración de URLs para un simulador servido en base
+// (p. ej. el URL de un httptest.Server)
+func URLs(base string) core.URLs {
+	base = strings.TrimSuffix(base, "/")
+	return core.URLs{
+		EldniDatos:    base + RutaEldniDatos,
+		EldniDigito:   base + RutaEldniDigito,
+		DniperuPagina: base + RutaDniperuPagina,
+		DniperuAjax:   base + RutaDniperuAjax,
+	}
+}
+
+// Consultas devuelve cuántas consultas (POST) recibió cada sitio
+func (s *Simulador) Consultas() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	copia := make(map[string]int, len(s.consultas))
+	for k, v := range s.consultas {
+		copia[k] = v
+	}
+	return copia
+}
+
+// nuevoToken emite un token o nonce de n bytes con la vida configurada
+func (s *Simulador) nuevoToken(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	token := hex.EncodeToString(b)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vida := s.cfg.VidaToken
+	if vida <= 0 {
+		vida = -1
+	}
+	s.tokens[token] = vida
+	return token
+}
+
+// usarToken descuenta una consulta al token; false si no existe o venció
+func (s *Simulador) usarToken(token string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vida, ok := s.tokens[token]
+	if !ok || vida == 0 {
+		return false
+	}
+	if vida > 0 {
+		s.tokens[token] = vida - 1
+	}
+	return true
+}
+
+// limitar cuenta la consulta al sitio y responde 429 si le toca
+func (s *Simulador) limitar(w http.ResponseWriter, sitio string) bool {
+	s.mu.Lock()
+	s.consultas[sitio]++
+	n := s.consultas[sitio]
+	s.mu.Unlock()
+
+	if s.cfg.LimiteCada <= 0 || n%s.cfg.LimiteCada != 0 {
+		return false
+	}
+	if s.cfg.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(s.cfg.RetryAfter.Seconds())))
+	}
+	http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+	return true
+}
+
+// buscar devuelve la persona del DNI y si existe; como en los sitios
+// reales, un DNI que no son 8 dígitos no existe
+func (s *Simulador) buscar(dni string) (core.Persona, bool) {
+	if contiene(s.cfg.NoEncontrados, dni) || contiene(s.cfg.Denegados, dni) {
+		return core.Persona{}, false
+	}
+	if s.cfg.Personas != nil {
+		p, ok := s.cfg.Personas[dni]
+		return p, ok
+	}
+	return PersonaFicticia(dni)
+}
+
+func contiene(lista []string, s string) bool {
+	for _, v := range lista {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	nombresFicticios   = []string{"JUAN CARLOS", "MARIA ELENA", "JOSE LUIS", "ROSA ANGELICA", "LUIS ALBERTO", "ANA LUCIA", "CARLOS ENRIQUE", "CARMEN ROSA", "JORGE", "PATRICIA"}
+	apellidosFicticios = []string{"QUISPE", "FLORES", "SANCHEZ", "RODRIGUEZ", "GARCIA", "MAMANI", "HUAMAN", "CHAVEZ", "TORRES", "RAMOS"}
+)
+
+// PersonaFicticia arma datos fijos a partir de los dígitos del DNI, para que
+// cada DNI devuelva siempre lo mismo; false si dni no son 8 dígitos
+func PersonaFicticia(dni string) (core.Persona, bool) {
+	digito, _, err := core.CalcularDigitoVerificador(dni)
+	if err != nil {
+		return core.Persona{}, false
+	}
+	d := func(i int) int { return int(dni[i] - '0') }
+	return core.Persona{
+		DNI:             dni,
+		Nombres:         nombresFicticios[d(7)],
+		ApellidoPaterno: apellidosFicticios[d(6)],
+		ApellidoMaterno: apellidosFicticios[d(5)],
+		FechaNacimiento: fmt.Sprintf("%02d/%02d/%d", 1+d(4)*2+d(3)%2, 1+d(2), 1950+d(1)*5+d(0)),
+		CodigoVerif:     digito,
+	}, true
+}
+
+// formulario es la página de eldni.com con el token CSRF y el campo del DNI
+func (s *Simulador) formulario(w http.ResponseWriter, campo, resultado string) {
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html><body>
+<form method="POST">
+<input type="hidden" name="_token" value="%s">
+<input type="text" name="%s" maxlength="8">
+<button type="submit">Buscar</button>
+</form>
+%s
+</body></html>
+`, s.nuevoToken(20), campo, resultado)
+}
+
+// recibirFormulario valida método y token del POST; responde 419 como
+// Laravel cuando el token no sirve
+func (s *Simulador) recibirFormulario(w http.ResponseWriter, r *http.Request, sitio string) bool {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	if s.limitar(w, sitio) {
+		return false
+	}
+	if !s.usarToken(r.PostFormValue("_token")) {
+		http.Error(w, "Page Expired", 419)
+		return false
+	}
+	return true
+}
+
+func (s *Simulador) eldniDatos(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		s.formulario(w, "dni", "")
+		return
+	}
+	if !s.recibirFormulario(w, r, "eldni") {
+		return
+	}
+
+	dni := r.PostFormValue("dni")
+	p, ok := s.buscar(dni)
+	if !ok {
+		s.formulario(w, "dni", `<p class="text-danger">No se encontraron resultados</p>`)
+		return
+	}
+	s.formulario(w, "dni", fmt.Sprintf(`
+<input type="text" id="nombres" value="%s" readonly>
+<input type="text" id="apellidop" value="%s" readonly>
+<input type="text" id="apellidom" value="%s" readonly>
+<table class="table"><thead><tr><th>DNI</th><th>Nombres</th><th>Apellido Paterno</th><th>Apellido Materno</th></tr></thead>
+<tbody><tr><td>%s</td><td>%s</td><td>%s</td><td>%s</td></tr></tbody></table>`,
+		html.EscapeString(p.Nombres), html.EscapeString(p.ApellidoPaterno), html.EscapeString(p.ApellidoMaterno),
+		dni, html.EscapeString(p.Nombres), html.EscapeString(p.ApellidoPaterno), html.EscapeString(p.ApellidoMaterno)))
+}
+
+func (s *Simulador) eldniDigito(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		s.formulario(w, "dniveri", "")
+		return
+	}
+	if !s.recibirFormulario(w, r, "eldni") {
+		return
+	}
+
+	// El dígito se calcula para cualquier DNI válido, exista o no
+	digito, _, err := core.CalcularDigitoVerificador(r.PostFormValue("dniveri"))
+	if err != nil {
+		s.formulario(w, "dniveri", `<p class="text-danger">DNI inválido</p>`)
+		return
+	}
+	s.formulario(w, "dniveri", fmt.Sprintf(`<p>El dígito verificador es <mark>%s</mark></p>`, digito))
+}
+
+func (s *Simulador) dniperuPagina(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html><head>
+<script>var fecha_vars = {"ajax_url":"%s","nonce":"%s"};</script>
+</head><body><form id="buscar-fecha"><input type="text" name="dni"></form></body></html>
+`, RutaDniperuAjax, s.nuevoToken(5))
+}
+
+// dniperuAjax responde como WordPress: -1 si el nonce no sirve, 0 si la
+// acción no devuelve nada y JSON con success en el resto
+func (s *Simulador) dniperuAjax(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "0", http.StatusBadRequest)
+		return
+	}
+	if s.limitar(w, "dniperu") {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	dni := r.PostFormValue("dni")
+	if r.PostFormValue("action") != "buscar_fecha" {
+		fmt.Fprint(w, "0")
+		return
+	}
+	if !s.usarToken(r.PostFormValue("security")) || contiene(s.cfg.Denegados, dni) {
+		fmt.Fprint(w, "-1")
+		return
+	}
+
+	p, ok := s.buscar(dni)
+	if !ok {
+		fmt.Fprint(w, "0")
+		return
+	}
+
+	var respuesta struct {
+		Success bool `json:"success"`
+		Data    struct {
+			DNI             string `json:"dni"`
+			Nombres         string `json:"nombres"`
+			FechaNacimiento string `json:"fechaNacimiento"`
+		} `json:"data"`
+	}
+	respuesta.Success = true
+	respuesta.Data.DNI = dni
+	respuesta.Data.Nombres = strings.Join([]string{p.Nombres, p.ApellidoPaterno, p.ApellidoMaterno}, " ")
+	respuesta.Data.FechaNacimiento = p.FechaNacimiento
+	json.NewEncoder(w).Encode(respuesta)
+}
+
+// respuestaGzip escribe el cuerpo comprimido
+type respuestaGzip struct {
+	http.ResponseWriter
+	gz *gzip.Writer
+}
+
+func (r respuestaGzip) Write(b []byte) (int, error) {
+	return r.gz.Write(b)
+}
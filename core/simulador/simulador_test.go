@@ -0,0 +1,140 @@
+package simulador_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"personas/core"
+	"personas/core/simulador"
+)
+
+// TestWorkers corre varios workers como los de enrich, cada uno con sus
+// fuentes y los limitadores compartidos, contra un simulador que responde
+// 429, vence tokens y comprime; cada DNI debe quedar completo o, si el sitio
+// no lo tiene, con los campos pendientes
+func TestWorkers(t *testing.T) {
+	const noEncontrado, denegado = "40000098", "40000099"
+	sim := simulador.Nuevo(simulador.Config{
+		NoEncontrados: []string{noEncontrado},
+		Denegados:     []string{denegado},
+		LimiteCada:    15,
+		RetryAfter:    time.Second,
+		VidaToken:     4, // dniperu renueva el nonce cada 4 consultas
+		Gzip:          true,
+	})
+	srv := sim.Iniciar()
+	defer srv.Close()
+
+	cfg := core.ConfigDefecto()
+	cfg.URLs = simulador.URLs(srv.URL)
+	cfg.Timeout = 5 * time.Second
+	cuotas := make(map[string]core.Cuota)
+	for fuente := range core.LimitesDefecto() {
+		cuotas[fuente] = core.Cuota{PorMinuto: 6000, Rafaga: 10}
+	}
+	limites := core.NuevosLimitadores(cuotas)
+	reintentos := core.PoliticaReintento{MaxIntentos: 4, EsperaBase: 10 * time.Millisecond, EsperaMax: 100 * time.Millisecond, Plazo: 30 * time.Second}
+
+	dnis := []string{noEncontrado, denegado}
+	for i := 0; i < 20; i++ {
+		dnis = append(dnis, fmt.Sprintf("4%d%d%d%d%d%d%d", i%10, (i*3)%10, (i*7)%10, i%4, (i*5)%10, (i*9)%10, i%10))
+	}
+
+	trabajos := make(chan string)
+	var mu sync.Mutex
+	resultados := make(map[string]*core.Enriquecimiento)
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		registro := core.NuevoRegistro(
+			core.FuenteDigitoLocal{},
+			core.NuevaFuenteEldniDatos(cfg.OpcionesRed(limites.Para(core.NombreEldniDatos, cfg.URLs.EldniDatos))...),
+			core.NewDNIScraper(cfg.OpcionesRed(limites.Para(core.NombreDniperu, cfg.URLs.DniperuAjax))...),
+			core.NuevaFuenteEldniDigito(cfg.OpcionesRed(limites.Para(core.NombreEldniDigito, cfg.URLs.EldniDigito))...),
+		)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for dni := range trabajos {
+				e := core.Enriquecer(context.Background(), registro, core.Persona{DNI: dni}, reintentos)
+				mu.Lock()
+				resultados[dni] = e
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, dni := range dnis {
+		trabajos <- dni
+	}
+	close(trabajos)
+	wg.Wait()
+
+	for _, dni := range dnis {
+		e := resultados[dni]
+		if len(e.Conflictos) > 0 {
+			t.Errorf("%s: %d campos en conflicto", dni, len(e.Conflictos))
+		}
+		esperada, _ := simulador.PersonaFicticia(dni)
+		if dni == noEncontrado || dni == denegado {
+			esperada = core.Persona{DNI: dni, CodigoVerif: esperada.CodigoVerif}
+		}
+		for _, c := range core.Campos {
+			if obtenido := e.Persona.Valor(c); obtenido != esperada.Valor(c) {
+				t.Errorf("%s: %s=%q, se esperaba %q (errores: %v)", dni, c, obtenido, esperada.Valor(c), e.Errores)
+			}
+		}
+	}
+	if n := sim.Consultas()["eldni"]; n < len(dnis) {
+		t.Errorf("eldni recibió %d consultas para %d DNIs", n, len(dnis))
+	}
+}
+
+// TestDNIInvalido comprueba que un DNI que no son 8 dígitos se responde
+// como no encontrado en vez de tumbar el handler
+func TestDNIInvalido(t *testing.T) {
+	srv := simulador.Nuevo(simulador.Config{}).Iniciar()
+	defer srv.Close()
+	urls := simulador.URLs(srv.URL)
+	reglas := core.ReglasDefecto()
+	leer := func(resp *http.Response, err error) string {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s %s: %s", resp.Request.Method, resp.Request.URL, resp.Status)
+		}
+		cuerpo, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(cuerpo)
+	}
+
+	for _, dni := range []string{"", "123", "1234567", "12a45678", "123456789"} {
+		token, err := reglas[core.NombreEldniDatos].ExtraerToken(leer(http.Get(urls.EldniDatos)))
+		if err != nil || token == "" {
+			t.Fatalf("sin token: %v", err)
+		}
+		cuerpo := leer(http.PostForm(urls.EldniDatos, url.Values{"_token": {token}, "dni": {dni}}))
+		if !strings.Contains(cuerpo, "No se encontraron resultados") {
+			t.Errorf("eldni con %q: %s", dni, cuerpo)
+		}
+
+		nonce, err := reglas[core.NombreDniperu].ExtraerToken(leer(http.Get(urls.DniperuPagina)))
+		if err != nil || nonce == "" {
+			t.Fatalf("sin nonce: %v", err)
+		}
+		cuerpo = leer(http.PostForm(urls.DniperuAjax, url.Values{"action": {"buscar_fecha"}, "security": {nonce}, "dni": {dni}}))
+		if cuerpo != "0" {
+			t.Errorf("dniperu con %q: %s", dni, cuerpo)
+		}
+	}
+}
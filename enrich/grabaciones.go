@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"personas/core"
+	"personas/core/grabacion"
+)
+
+// DirGrabaciones es el valor por defecto de -dir, relativo a la raíz del repo
+const DirGrabaciones = "core/testdata/grabaciones"
+
+// dirRepo ubica ruta, relativa a la raíz del repo, tanto si el comando corre
+// desde la raíz como desde enrich/
+func dirRepo(ruta string) string {
+	if desdeModulo := filepath.Join("..", ruta); !esDirectorio(ruta) && esDirectorio(desdeModulo) {
+		return desdeModulo
+	}
+	return ruta
+}
+
+func esDirectorio(ruta string) bool {
+	st, err := os.Stat(ruta)
+	return err == nil && st.IsDir()
+}
+
+// reemplazosFlag acumula valores de -reemplazar con la forma real=ficticio
+type reemplazosFlag map[string]string
+
+func (r reemplazosFlag) String() string { return "" }
+
+func (r reemplazosFlag) Set(valor string) error {
+	real, falso, ok := strings.Cut(valor, "=")
+	if !ok || real == "" {
+		return fmt.Errorf("se esperaba real=ficticio")
+	}
+	r[real] = falso
+	return nil
+}
+
+// grabaciones reproduce las respuestas grabadas contra cada fuente y compara
+// con lo esperado en casos.json; con -grabar consulta el sitio real y agrega
+// el caso con el DNI, los nombres y las fechas reemplazados por ficticios.
+// Devuelve error si algún caso no coincide.
+func grabaciones(ctx context.Context, cfg core.Config, args []string) error {
+	fs := flag.NewFlagSet(CmdGrabaciones, flag.ContinueOnError)
+	dir := fs.String("dir", dirRepo(DirGrabaciones), "directorio de grabaciones")
+	grabar := fs.Bool("grabar", false, "consultar el sitio real y guardar la respuesta")
+	fuente := fs.String("fuente", "", "fuente a grabar")
+	dni := fs.String("dni", "", "DNI a grabar")
+	reemplazos := make(reemplazosFlag)
+	fs.Var(reemplazos, "reemplazar", "otro dato personal a reemplazar al grabar, como real=ficticio (repetible)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *grabar {
+		if *fuente == "" || *dni == "" {
+			return fmt.Errorf("-grabar necesita -fuente y -dni")
+		}
+		return grabarCaso(ctx, cfg, *dir, *fuente, *dni, reemplazos)
+	}
+
+	casos, err := grabacion.LeerCasos(*dir)
+	if err != nil {
+		return err
+	}
+	if len(casos) == 0 {
+		return fmt.Errorf("no hay casos en %s", *dir)
+	}
+
+	// Las grabaciones están hechas contra los sitios reales, no contra los
+	// espejos que pueda indicar la configuración
+	cfg.URLs = core.ConfigDefecto().URLs
+
+	fallidos := 0
+	for _, c := range casos {
+		if problema := grabacion.Reproducir(ctx, *dir, c, opcionesGrabacion(cfg)...); problema != "" {
+			fallidos++
+			fmt.Printf("❌ %s %s: %s\n", c.Fuente, c.DNI, problema)
+			continue
+		}
+		fmt.Printf("✅ %s %s: %s\n", c.Fuente, c.DNI, c.Resultado)
+	}
+
+	fmt.Printf("\n📊 Resultados: %d correctos, %d fallidos de %d casos\n", len(casos)-fallidos, fallidos, len(casos))
+	if fallidos > 0 {
+		return fmt.Errorf("%d casos no coinciden con lo grabado", fallidos)
+	}
+	return nil
+}
+
+// opcionesGrabacion son las opciones de red de cfg sin límite de ritmo
+func opcionesGrabacion(cfg core.Config) []core.Opcion {
+	return []core.Opcion{core.ConURLs(cfg.URLs), core.ConTimeout(cfg.Timeout), core.ConReglas(cfg.Extraccion)}
+}
+
+// grabarCaso consulta dni en el sitio real y, ya con los datos que trajo,
+// guarda las respuestas y el caso limpios
+func grabarCaso(ctx context.Context, cfg core.Config, dir, nombre, dni string, reemplazos map[string]string) error {
+	tr := grabacion.NuevoTransporte(dir, grabacion.ModoGrabar)
+	fuente, err := grabacion.NuevaFuente(nombre, tr, opcionesGrabacion(cfg)...)
+	if err != nil {
+		return err
+	}
+
+	persona, err := fuente.Lookup(ctx, dni)
+	ficticio, ok := reemplazos[dni]
+	if !ok {
+		var errDNI error
+		if ficticio, errDNI = grabacion.DNIFicticio(dir); errDNI != nil {
+			return errDNI
+		}
+	}
+	var nombres []string
+	if persona != nil {
+		nombres = []string{persona.Nombres, persona.ApellidoPaterno, persona.ApellidoMaterno}
+	}
+	limpiador := grabacion.NuevoLimpiadorAutomatico(dni, ficticio, nombres, reemplazos)
+
+	c := grabacion.Caso{
+		Fuente:    nombre,
+		DNI:       ficticio,
+		Resultado: core.ClasificarResultado(err),
+	}
+	if err == nil {
+		c.Esperado = make(map[string]string)
+		for _, campo := range fuente.Fields() {
+			c.Esperado[string(campo)] = limpiador.Limpiar(persona.Valor(campo))
+		}
+	}
+
+	if err := tr.Guardar(limpiador); err != nil {
+		return err
+	}
+	if err := grabacion.GuardarCaso(dir, c); err != nil {
+		return err
+	}
+	fmt.Printf("💾 Caso %s %s guardado como %s\n", c.Fuente, c.DNI, c.Resultado)
+	for campo, valor := range c.Esperado {
+		if campo != string(core.CampoCodigoVerif) && valor != "" && valor == persona.Valor(core.Campo(campo)) {
+			fmt.Printf("⚠️  %s=%q no se reemplazó; revisar antes de versionar\n", campo, valor)
+		}
+	}
+	return nil
+}
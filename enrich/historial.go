@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"personas/core"
+)
+
+// mostrarHistorial lista todos los cambios de un DNI o, con -revertir,
+// devuelve un campo al valor que tenía antes de ese cambio
+func mostrarHistorial(ctx context.Context, db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet(CmdHistorial, flag.ContinueOnError)
+	revertir := fs.Int64("revertir", 0, "id del cambio a revertir")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *revertir != 0 {
+		c, err := core.RevertirCambio(ctx, db, *revertir)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("↩️  %s de %s vuelve a %s\n", c.Campo, c.DNI, textoValor(c.Anterior))
+		return nil
+	}
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("uso: %s <dni> | %s -revertir <id>", CmdHistorial, CmdHistorial)
+	}
+	dni := fs.Arg(0)
+	cambios, err := core.HistorialDNI(ctx, db, dni)
+	if err != nil {
+		return err
+	}
+	if len(cambios) == 0 {
+		fmt.Printf("Sin cambios registrados para %s\n", dni)
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tESCRITO\tCAMPO\tANTERIOR\tNUEVO\tFUENTE\tCONFIANZA\tOBTENIDO\tCORRIDA\tREVISIÓN")
+	for _, c := range cambios {
+		confianza := "-"
+		if c.Confianza.Valid {
+			confianza = fmt.Sprintf("%.2f", c.Confianza.Float64)
+		}
+		revision := "-"
+		if c.Revision.Valid {
+			revision = fmt.Sprintf("#%d %s por %s", c.Revision.Int64, c.Decision.String, c.Autor.String)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", c.ID,
+			c.EscritoEn.Local().Format("2006-01-02 15:04:05"), c.Campo,
+			textoValor(c.Anterior), textoValor(c.Nuevo), c.Fuente, confianza,
+			c.ObtenidoEn.Local().Format("2006-01-02 15:04:05"), c.Corrida, revision)
+	}
+	return tw.Flush()
+}
+
+func textoValor(v sql.NullString) string {
+	if !v.Valid {
+		return "NULL"
+	}
+	return fmt.Sprintf("%q", v.String)
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"personas/core"
+)
+
+// NumWorkers es el valor por defecto de -workers. El ritmo de cada sitio lo
+// ponen los limitadores compartidos (ver -limite), así que por defecto no hay
+// pausa extra entre DNIs.
+const NumWorkers = 2
+
+// VencimientoTrabajo es el valor por defecto de -vencimiento: un job que
+// lleva más que esto en running se considera abandonado
+const VencimientoTrabajo = 10 * time.Minute
+
+// Comandos
+const (
+	CmdRun       = "run"       // encola lo que falte y procesa la cola
+	CmdEncolar   = "encolar"   // solo encola
+	CmdCola      = "cola"      // muestra el estado de la cola
+	CmdHistorial = "historial" // muestra (o revierte) los cambios de un DNI
+	CmdRevisar   = "review"    // lista y resuelve los campos en revisión
+	CmdMigrar    = "migrate"   // aplica, revierte o lista las migraciones del esquema
+
+	CmdGrabaciones = "grabaciones" // reproduce (o graba) las respuestas de testdata
+	CmdSimulador   = "simulador"   // sirve un imitador local de los sitios
+	CmdReglas      = "rules"       // lista o prueba las reglas de extracción
+)
+
+func main() {
+	base := core.ConfigDefecto()
+	base.Workers = NumWorkers
+	base.Pausa = 0
+
+	cfg, args, err := core.CargarConfig("enrich", os.Args[1:], base)
+	if err != nil {
+		log.Fatalf("Error en la configuración: %v", err)
+	}
+	if err := core.ConfigurarLogs(cfg.Log); err != nil {
+		log.Fatalf("Error en la configuración: %v", err)
+	}
+
+	drenar, abortar, stop := core.ContextoApagado(context.Background())
+	defer stop()
+
+	if cfg.Metricas != "" {
+		core.ServirMetricas(abortar, cfg.Metricas)
+	}
+
+	cmd := CmdRun
+	if len(args) > 0 {
+		cmd = args[0]
+		args = args[1:]
+	}
+
+	// Las grabaciones, el simulador y las reglas no usan la base de datos
+	switch cmd {
+	case CmdGrabaciones:
+		if err := grabaciones(abortar, cfg, args); err != nil {
+			log.Fatalf("Error con las grabaciones: %v", err)
+		}
+		return
+	case CmdSimulador:
+		if err := simular(drenar, args); err != nil {
+			log.Fatalf("Error en el simulador: %v", err)
+		}
+		return
+	case CmdReglas:
+		if err := reglas(cfg, args); err != nil {
+			log.Fatalf("Error con las reglas de extracción: %v", err)
+		}
+		return
+	}
+
+	db, err := core.ConectarDB(cfg.DB)
+	if err != nil {
+		log.Fatalf("Error conectando a la base de datos: %v", err)
+	}
+	defer db.Close()
+
+	// Las migraciones son lo único que puede correr con el esquema viejo
+	if cmd == CmdMigrar {
+		if err := migrar(abortar, db, args); err != nil {
+			log.Fatalf("Error migrando el esquema: %v", err)
+		}
+		return
+	}
+
+	if err := core.ComprobarEsquema(abortar, db); err != nil {
+		log.Fatalf("Error en el esquema de la base de datos: %v", err)
+	}
+
+	startTime := time.Now()
+
+	switch cmd {
+	case CmdRun:
+		err = ejecutar(drenar, abortar, db, cfg, args)
+	case CmdEncolar:
+		limites := core.NuevosLimitadores(cfg.Limites)
+		err = encolar(abortar, db, nuevoRegistro(cfg, limites, nuevoDniperu(cfg, limites)))
+	case CmdCola:
+		err = mostrarCola(abortar, db)
+	case CmdHistorial:
+		err = mostrarHistorial(abortar, db, args)
+	case CmdRevisar:
+		err = revisar(abortar, db, args)
+	default:
+		err = fmt.Errorf("comando desconocido %q (usar %s, %s, %s, %s, %s, %s, %s, %s o %s)",
+			cmd, CmdRun, CmdEncolar, CmdCola, CmdHistorial, CmdRevisar, CmdMigrar, CmdGrabaciones, CmdSimulador, CmdReglas)
+	}
+	if err != nil {
+		log.Fatalf("Error enriqueciendo personas: %v", err)
+	}
+
+	if drenar.Err() != nil {
+		fmt.Printf("⚠️  Proceso interrumpido tras %v\n", time.Since(startTime))
+		return
+	}
+	fmt.Printf("🎉 Proceso completado en %v\n", time.Since(startTime))
+}
+
+// nuevoDniperu crea la sesión de dniperu.com; el sitio limita por IP, así
+// que todos los workers la comparten
+func nuevoDniperu(cfg core.Config, limites *core.Limitadores) *core.DNIScraper {
+	limitador := limites.Para(core.NombreDniperu, cfg.URLs.DniperuAjax)
+	return core.NewDNIScraper(cfg.OpcionesRed(limitador)...)
+}
+
+// nuevoRegistro arma las fuentes de un worker en orden de preferencia: el
+// cálculo local antes que cualquier consulta web. Los limitadores son por
+// host, así que se comparten entre los registros de todos los workers.
+func nuevoRegistro(cfg core.Config, limites *core.Limitadores, dniperu *core.DNIScraper) *core.Registro {
+	datos := limites.Para(core.NombreEldniDatos, cfg.URLs.EldniDatos)
+	digito := limites.Para(core.NombreEldniDigito, cfg.URLs.EldniDigito)
+	return core.NuevoRegistro(
+		core.FuenteDigitoLocal{},
+		core.NuevaFuenteEldniDatos(cfg.OpcionesRed(datos)...),
+		dniperu,
+		core.NuevaFuenteEldniDigito(cfg.OpcionesRed(digito)...),
+	)
+}
+
+func encolar(ctx context.Context, db *sql.DB, registro *core.Registro) error {
+	nuevos, err := core.EncolarTrabajos(ctx, db, registro)
+	if err != nil {
+		return err
+	}
+	slog.Info("jobs nuevos en la cola", "jobs", nuevos)
+	return nil
+}
+
+func mostrarCola(ctx context.Context, db *sql.DB) error {
+	conteo, err := core.ContarTrabajos(ctx, db)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("📋 Cola: %d pendientes, %d en curso, %d hechos, %d fallidos\n",
+		conteo[core.EstadoPendiente], conteo[core.EstadoEnCurso],
+		conteo[core.EstadoHecho], conteo[core.EstadoFallido])
+	return nil
+}
+
+// ejecutar recupera los jobs abandonados, encola lo que falte y procesa la
+// cola. Otros procesos pueden estar trabajando la misma cola a la vez.
+func ejecutar(drenar, abortar context.Context, db *sql.DB, cfg core.Config, args []string) error {
+	politica := core.PoliticaColaDefecto()
+	politica.Reconsulta = cfg.Reconsulta
+	fs := flag.NewFlagSet(CmdRun, flag.ContinueOnError)
+	fs.IntVar(&politica.MaxIntentos, "max-intentos", politica.MaxIntentos, "intentos por DNI y fuente antes de darlo por fallido")
+	vencimiento := fs.Duration("vencimiento", VencimientoTrabajo, "tiempo en running tras el cual un job se considera abandonado")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	recuperados, err := core.RecuperarTrabajos(abortar, db, *vencimiento)
+	if err != nil {
+		return err
+	}
+	if recuperados > 0 {
+		slog.Info("jobs abandonados vuelven a la cola", "jobs", recuperados)
+	}
+
+	limites := core.NuevosLimitadores(cfg.Limites)
+	dniperu := nuevoDniperu(cfg, limites)
+
+	if err := encolar(abortar, db, nuevoRegistro(cfg, limites, dniperu)); err != nil {
+		return err
+	}
+
+	slog.Info("iniciando enriquecimiento", "workers", cfg.Workers)
+	if cfg.Metricas != "" {
+		go core.VigilarCola(abortar, db)
+	}
+	return enriquecerPersonas(drenar, abortar, db, cfg, limites, dniperu, politica)
+}
+
+// resultado es lo que un worker entrega para guardar: el enriquecimiento o
+// el error que impidió intentarlo
+type resultado struct {
+	WorkerID int
+	Trabajo  *core.Trabajo
+	E        *core.Enriquecimiento
+	Error    error
+}
+
+// enriquecerPersonas deja de reclamar jobs cuando se cancela drenar; las
+// consultas en curso y la escritura de sus resultados usan abortar. Un job
+// reclamado que no llega a cerrarse lo recupera la siguiente corrida.
+func enriquecerPersonas(drenar, abortar context.Context, db *sql.DB, cfg core.Config, limites *core.Limitadores, dniperu *core.DNIScraper, politica core.PoliticaCola) error {
+	// El total es lo pendiente al empezar; otros procesos pueden estar
+	// trabajando la misma cola. Para estimar el tiempo restante se toma el
+	// límite de eldni_datos, la primera fuente web que se consulta.
+	conteo, err := core.ContarTrabajos(abortar, db)
+	if err != nil {
+		return err
+	}
+	progreso := core.NuevoProgreso(conteo[core.EstadoPendiente], cfg.Workers, core.RitmoLimite(cfg.Limites, core.NombreEldniDatos))
+
+	resultadoChan := make(chan resultado, cfg.Workers)
+	var wg sync.WaitGroup
+
+	for i := 0; i < cfg.Workers; i++ {
+		wg.Add(1)
+		go worker(drenar, abortar, db, i+1, nuevoRegistro(cfg, limites, dniperu), cfg.Reintentos, cfg.Pausa, progreso, resultadoChan, &wg)
+	}
+
+	var resumen Resumen
+	var processingWg sync.WaitGroup
+	processingWg.Add(1)
+	go func() {
+		defer processingWg.Done()
+		for r := range resultadoChan {
+			progreso.Avanzar(guardarResultado(abortar, db, r, politica, &resumen))
+		}
+	}()
+
+	wg.Wait()
+	close(resultadoChan)
+	processingWg.Wait()
+	progreso.Terminar()
+
+	resumen.Imprimir()
+	return mostrarCola(abortar, db)
+}
+
+func worker(drenar, abortar context.Context, db *sql.DB, workerID int, registro *core.Registro, reintentos core.PoliticaReintento, pausa time.Duration, progreso *core.Progreso, resultadoChan chan<- resultado, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer progreso.WorkerTerminado(workerID)
+
+	nombre := core.NombreTrabajador("enrich", workerID)
+	for drenar.Err() == nil {
+		t, err := core.ReclamarTrabajo(abortar, db, nombre)
+		if err != nil {
+			slog.Error("no se pudo reclamar jobs", "worker_id", workerID, "error", err)
+			return
+		}
+		if t == nil {
+			return
+		}
+
+		p, err := core.ObtenerPersona(abortar, db, t.DNI)
+		if err != nil {
+			resultadoChan <- resultado{WorkerID: workerID, Trabajo: t, Error: err}
+			continue
+		}
+
+		sub := registro.Subconjunto(t.Fuentes)
+		slog.Debug("enriqueciendo", "worker_id", workerID, core.AtributoDNI(p.DNI), "fuentes", nombresFuentes(sub.Fuentes()))
+
+		e := core.Enriquecer(progreso.ConWorker(abortar, workerID), sub, p, reintentos)
+		resultadoChan <- resultado{WorkerID: workerID, Trabajo: t, E: e}
+
+		if usaRed(e.Consultadas) {
+			core.Dormir(drenar, pausa)
+		}
+	}
+}
+
+// guardarResultado cierra el job de r y devuelve el error que impidió
+// completar el DNI, si lo hubo
+func guardarResultado(ctx context.Context, db *sql.DB, r resultado, politica core.PoliticaCola, resumen *Resumen) error {
+	dni := r.Trabajo.DNI
+	attrs := []any{"worker_id", r.WorkerID, core.AtributoDNI(dni)}
+	if r.Error != nil {
+		resumen.ErroresBD++
+		slog.Error("error leyendo la persona", append(attrs, "error", r.Error)...)
+		if err := core.FallarTrabajo(ctx, db, r.Trabajo, r.Error, politica); err != nil {
+			slog.Error("error cerrando el job", append(attrs, "error", err)...)
+		}
+		return r.Error
+	}
+
+	e := r.E
+	for _, fuente := range e.Consultadas {
+		err := e.Errores[fuente]
+		consulta := append([]any{"worker_id", r.WorkerID},
+			core.AtributosConsulta(dni, fuente, e.Intentos[fuente], e.Duraciones[fuente], err)...)
+		if err != nil {
+			slog.Warn("consulta fallida", append(consulta, "error", err)...)
+		} else {
+			slog.Debug("consulta completada", consulta...)
+		}
+	}
+
+	if err := core.CompletarTrabajo(ctx, db, r.Trabajo, e, politica); err != nil {
+		resumen.ErroresBD++
+		slog.Error("error guardando el enriquecimiento", append(attrs, "error", err)...)
+		return err
+	}
+
+	resumen.EnRevision += len(e.Conflictos)
+	for _, c := range e.Conflictos {
+		slog.Warn("nombre en conflicto, queda en revisión", core.AtributosConflicto(c)...)
+	}
+
+	if len(e.Nuevos) == 0 {
+		resumen.SinDatos++
+		slog.Warn("ninguna fuente aportó datos", attrs...)
+		return errSinDatos
+	}
+
+	resumen.Registrar(e)
+	var partes []string
+	for _, c := range core.Campos {
+		if fuente, ok := e.Procedencia[c]; ok {
+			partes = append(partes, fmt.Sprintf("%s←%s", c, fuente))
+		}
+	}
+	slog.Info("DNI enriquecido", append(attrs, "campos", strings.Join(partes, ", "), "pendientes", len(e.Pendientes))...)
+	return nil
+}
+
+// errSinDatos cuenta como error en la barra de progreso un DNI que ninguna
+// fuente pudo completar
+var errSinDatos = errors.New("ninguna fuente aportó datos")
+
+// Resumen acumula los contadores que se muestran al final
+type Resumen struct {
+	Completas  int
+	Parciales  int
+	SinDatos   int
+	ErroresBD  int
+	EnRevision int            // campos en conflicto que quedaron en personas_review
+	PorFuente  map[string]int // campos aportados por cada fuente
+}
+
+func (r *Resumen) Registrar(e *core.Enriquecimiento) {
+	if len(e.Pendientes) == 0 {
+		r.Completas++
+	} else {
+		r.Parciales++
+	}
+	if r.PorFuente == nil {
+		r.PorFuente = make(map[string]int)
+	}
+	for _, fuente := range e.Procedencia {
+		r.PorFuente[fuente]++
+	}
+}
+
+func (r *Resumen) Imprimir() {
+	procesadas := r.Completas + r.Parciales + r.SinDatos + r.ErroresBD
+	fmt.Printf("\n📊 Resultados: %d completas, %d parciales, %d sin datos, %d errores BD de %d DNIs procesados\n",
+		r.Completas, r.Parciales, r.SinDatos, r.ErroresBD, procesadas)
+
+	fuentes := make([]string, 0, len(r.PorFuente))
+	for f := range r.PorFuente {
+		fuentes = append(fuentes, f)
+	}
+	sort.Strings(fuentes)
+	for _, f := range fuentes {
+		fmt.Printf("   %s: %d campos\n", f, r.PorFuente[f])
+	}
+	if r.EnRevision > 0 {
+		fmt.Printf("🔎 %d campos en conflicto quedaron para revisión\n", r.EnRevision)
+	}
+}
+
+func nombresFuentes(fuentes []core.Source) string {
+	nombres := make([]string, len(fuentes))
+	for i, f := range fuentes {
+		nombres[i] = f.Name()
+	}
+	return strings.Join(nombres, " → ")
+}
+
+// usaRed indica si se consultó alguna fuente además del cálculo local
+func usaRed(consultadas []string) bool {
+	for _, nombre := range consultadas {
+		if nombre != core.NombreDigitoLocal {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"personas/core"
+)
+
+// Subcomandos de migrate
+const (
+	MigrarArriba = "up"
+	MigrarAbajo  = "down"
+	MigrarEstado = "status"
+)
+
+// migrar aplica (up), revierte (down [-pasos n]) o lista (status) las
+// migraciones del esquema. La primera, que crea personas, no se revierte.
+func migrar(ctx context.Context, db *sql.DB, args []string) error {
+	sub := MigrarEstado
+	if len(args) > 0 {
+		sub = args[0]
+		args = args[1:]
+	}
+
+	switch sub {
+	case MigrarArriba:
+		aplicadas, err := core.MigrarArriba(ctx, db)
+		for _, m := range aplicadas {
+			fmt.Printf("⬆️  %04d_%s\n", m.Version, m.Nombre)
+		}
+		if err != nil {
+			return err
+		}
+		if len(aplicadas) == 0 {
+			fmt.Println("✅ El esquema ya está al día")
+		}
+		return nil
+
+	case MigrarAbajo:
+		fs := flag.NewFlagSet(CmdMigrar+" "+MigrarAbajo, flag.ContinueOnError)
+		pasos := fs.Int("pasos", 1, "cantidad de migraciones a revertir")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		revertidas, err := core.MigrarAbajo(ctx, db, *pasos)
+		for _, m := range revertidas {
+			fmt.Printf("⬇️  %04d_%s\n", m.Version, m.Nombre)
+		}
+		if err != nil {
+			return err
+		}
+		if len(revertidas) == 0 {
+			fmt.Println("No hay migraciones aplicadas")
+		}
+		return nil
+
+	case MigrarEstado:
+		estado, err := core.EstadoMigraciones(ctx, db)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSIÓN\tNOMBRE\tAPLICADA")
+		for _, e := range estado {
+			aplicada := "pendiente"
+			if e.Aplicada {
+				aplicada = e.AplicadaEn.Local().Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(tw, "%04d\t%s\t%s\n", e.Version, e.Nombre, aplicada)
+		}
+		return tw.Flush()
+	}
+
+	return fmt.Errorf("subcomando desconocido %q (usar %s, %s o %s)", sub, MigrarArriba, MigrarAbajo, MigrarEstado)
+}
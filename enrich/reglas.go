@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"personas/core"
+)
+
+// DirCasosReglas es el valor por defecto de -dir, relativo a la raíz del repo
+const DirCasosReglas = "core/testdata/reglas"
+
+// Subcomandos de rules
+const (
+	ReglasListar = "list"
+	ReglasProbar = "test"
+)
+
+// reglas lista las reglas de extracción en uso (list) o las prueba contra
+// las páginas guardadas (test [-dir d]). Son las que indica -reglas, así que
+// sirve para probar una versión nueva antes de desplegarla. Devuelve error
+// si algún caso no coincide.
+func reglas(cfg core.Config, args []string) error {
+	sub := ReglasListar
+	if len(args) > 0 {
+		sub = args[0]
+		args = args[1:]
+	}
+
+	switch sub {
+	case ReglasListar:
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "FUENTE\tVERSIÓN\tCAMPOS\tORIGEN")
+		for _, f := range cfg.Extraccion.Fuentes() {
+			rf := cfg.Extraccion[f]
+			var campos []string
+			for _, c := range core.Campos {
+				if len(rf.Campos[c]) > 0 {
+					campos = append(campos, fmt.Sprintf("%s(%d)", c, len(rf.Campos[c])))
+				}
+			}
+			fmt.Fprintf(tw, "%s\tv%d\t%s\t%s\n", f, rf.Version, strings.Join(campos, " "), rf.Origen)
+		}
+		return tw.Flush()
+
+	case ReglasProbar:
+		fs := flag.NewFlagSet(CmdReglas+" "+ReglasProbar, flag.ContinueOnError)
+		dir := fs.String("dir", dirRepo(DirCasosReglas), "directorio de páginas guardadas")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		casos, err := core.LeerCasosReglas(*dir)
+		if err != nil {
+			return err
+		}
+		if len(casos) == 0 {
+			return fmt.Errorf("no hay casos en %s", *dir)
+		}
+
+		fallidos := 0
+		for _, c := range casos {
+			diferencias, err := cfg.Extraccion.ProbarCaso(*dir, c)
+			if err != nil {
+				diferencias = []string{err.Error()}
+			}
+			version := 0
+			if rf, ok := cfg.Extraccion[c.Fuente]; ok {
+				version = rf.Version
+			}
+			if len(diferencias) > 0 {
+				fallidos++
+				fmt.Printf("❌ %s v%d %s: %s\n", c.Fuente, version, c.Archivo, strings.Join(diferencias, "; "))
+				continue
+			}
+			fmt.Printf("✅ %s v%d %s: %s\n", c.Fuente, version, c.Archivo, c.Nota)
+		}
+
+		fmt.Printf("\n📊 Resultados: %d correctos, %d fallidos de %d casos\n", len(casos)-fallidos, fallidos, len(casos))
+		if fallidos > 0 {
+			return fmt.Errorf("%d casos no coinciden con las reglas", fallidos)
+		}
+		return nil
+	}
+	return fmt.Errorf("subcomando desconocido %q (usar %s o %s)", sub, ReglasListar, ReglasProbar)
+}
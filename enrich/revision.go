@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"personas/core"
+)
+
+// Subcomandos de review
+const (
+	RevisarListar   = "list"
+	RevisarMostrar  = "show"
+	RevisarAceptar  = "accept"
+	RevisarRechazar = "reject"
+	RevisarEditar   = "edit"
+)
+
+// revisar lista las revisiones pendientes (list [-limite n]), muestra una
+// (show <id>) o la resuelve: accept <id> <candidato>, reject <id> o
+// edit <id> <valor>. Las decisiones llevan el autor de -autor, que puede ir
+// antes o después de los argumentos.
+func revisar(ctx context.Context, db *sql.DB, args []string) error {
+	sub := RevisarListar
+	if len(args) > 0 {
+		sub = args[0]
+		args = args[1:]
+	}
+
+	fs := flag.NewFlagSet(CmdRevisar+" "+sub, flag.ContinueOnError)
+	limite := fs.Int("limite", 20, "cantidad máxima de revisiones a listar")
+	autor := fs.String("autor", autorPorDefecto(), "quién toma la decisión")
+	posicionales, err := parsearIntercalado(fs, args)
+	if err != nil {
+		return err
+	}
+
+	var r *core.Revision
+	switch sub {
+	case RevisarListar:
+		revisiones, err := core.RevisionesPendientes(ctx, db, *limite)
+		if err != nil {
+			return err
+		}
+		if len(revisiones) == 0 {
+			fmt.Println("✅ No hay revisiones pendientes")
+			return nil
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for i := range revisiones {
+			imprimirRevision(tw, &revisiones[i])
+		}
+		return tw.Flush()
+
+	case RevisarMostrar:
+		id, err := argumentoID(fs.Name(), posicionales, 1)
+		if err != nil {
+			return err
+		}
+		if r, err = core.ObtenerRevision(ctx, db, id); err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		imprimirRevision(tw, r)
+		return tw.Flush()
+
+	case RevisarAceptar:
+		var id int64
+		if id, err = argumentoID(fs.Name(), posicionales, 2); err != nil {
+			return err
+		}
+		n, errN := strconv.Atoi(posicionales[1])
+		if errN != nil {
+			return fmt.Errorf("candidato inválido %q", posicionales[1])
+		}
+		r, err = core.AceptarRevision(ctx, db, id, n, *autor)
+
+	case RevisarRechazar:
+		var id int64
+		if id, err = argumentoID(fs.Name(), posicionales, 1); err != nil {
+			return err
+		}
+		r, err = core.RechazarRevision(ctx, db, id, *autor)
+
+	case RevisarEditar:
+		var id int64
+		if id, err = argumentoID(fs.Name(), posicionales, 2); err != nil {
+			return err
+		}
+		r, err = core.EditarRevision(ctx, db, id, strings.TrimSpace(posicionales[1]), *autor)
+
+	default:
+		return fmt.Errorf("subcomando desconocido %q (usar %s, %s, %s, %s o %s)",
+			sub, RevisarListar, RevisarMostrar, RevisarAceptar, RevisarRechazar, RevisarEditar)
+	}
+	if err != nil {
+		return err
+	}
+
+	if r.Estado == core.RevisionRechazada {
+		fmt.Printf("🚫 Revisión %d rechazada por %s: %s de %s sigue en %s\n", r.ID, *autor, r.Campo, r.DNI, textoValor(r.Actual))
+	} else {
+		fmt.Printf("✅ Revisión %d %s por %s: %s de %s queda en %s\n", r.ID, r.Estado, *autor, r.Campo, r.DNI, textoValor(r.Actual))
+	}
+	return nil
+}
+
+// imprimirRevision escribe la revisión y, debajo, sus candidatos numerados
+// como los espera accept. Las huérfanas se marcan en vez del valor actual.
+func imprimirRevision(w io.Writer, r *core.Revision) {
+	actual := "actual: " + textoValor(r.Actual)
+	if r.Huerfana {
+		actual = "⚠️  el DNI ya no está en personas"
+	}
+	fmt.Fprintf(w, "#%d\t%s\t%s\t%s\t%s\t%s\n", r.ID, r.DNI, r.Campo, r.Motivo,
+		r.CreadaEn.Local().Format("2006-01-02 15:04:05"), actual)
+	for i, c := range r.Candidatos {
+		metodo := c.Metodo
+		if metodo == "" {
+			metodo = "-"
+		}
+		fmt.Fprintf(w, "  %d)\t%s\t%q\t%s\t\t\n", i+1, c.Fuente, c.Valor, metodo)
+	}
+}
+
+// parsearIntercalado parsea args con fs aceptando flags también después de
+// los argumentos posicionales (accept 5 1 -autor ana), que flag deja sin
+// mirar, y devuelve los posicionales en orden. Lo que sigue a -- es siempre
+// posicional.
+func parsearIntercalado(fs *flag.FlagSet, args []string) ([]string, error) {
+	var posicionales []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		resto := fs.Args()
+		if consumidos := len(args) - len(resto); consumidos > 0 && args[consumidos-1] == "--" {
+			return append(posicionales, resto...), nil
+		}
+		if len(resto) == 0 {
+			return posicionales, nil
+		}
+		posicionales = append(posicionales, resto[0])
+		args = resto[1:]
+	}
+}
+
+// argumentoID lee el id de la revisión del primer argumento posicional y
+// comprueba que haya n
+func argumentoID(nombre string, posicionales []string, n int) (int64, error) {
+	if len(posicionales) != n {
+		return 0, fmt.Errorf("uso: %s <id>%s [-autor nombre]", nombre, strings.Repeat(" <valor>", n-1))
+	}
+	id, err := strconv.ParseInt(posicionales[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("id de revisión inválido %q", posicionales[0])
+	}
+	return id, nil
+}
+
+// autorPorDefecto es el usuario del sistema que corre el comando
+func autorPorDefecto() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"personas/core/simulador"
+)
+
+// simular sirve el simulador de eldni.com y dniperu.com en addr hasta que
+// se cancele ctx. Los programas se apuntan a él con las URLs que imprime.
+func simular(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet(CmdSimulador, flag.ContinueOnError)
+	addr := fs.String("addr", "127.0.0.1:8089", "dirección donde escuchar")
+	var cfg simulador.Config
+	fs.IntVar(&cfg.LimiteCada, "limite-cada", 0, "responder 429 cada N consultas a un sitio (0 nunca)")
+	fs.DurationVar(&cfg.RetryAfter, "retry-after", 0, "Retry-After de los 429")
+	fs.IntVar(&cfg.VidaToken, "vida-token", 0, "consultas que acepta cada token o nonce (0 sin vencimiento)")
+	fs.BoolVar(&cfg.Gzip, "gzip", false, "comprimir las respuestas")
+	noEncontrados := fs.String("no-encontrados", "", "DNIs sin datos, separados por coma")
+	denegados := fs.String("denegados", "", "DNIs a los que dniperu responde -1, separados por coma")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	cfg.NoEncontrados = separarLista(*noEncontrados)
+	cfg.Denegados = separarLista(*denegados)
+
+	srv := &http.Server{Addr: *addr, Handler: simulador.Nuevo(cfg)}
+	go func() {
+		<-ctx.Done()
+		cerrar, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(cerrar)
+	}()
+
+	urls := simulador.URLs("http://" + *addr)
+	fmt.Printf("🧪 Simulador escuchando en http://%s\n", *addr)
+	fmt.Printf("   urls:\n     eldni_datos: %s\n     eldni_digito: %s\n     dniperu_pagina: %s\n     dniperu_ajax: %s\n",
+		urls.EldniDatos, urls.EldniDigito, urls.DniperuPagina, urls.DniperuAjax)
+
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func separarLista(s string) []string {
+	var lista []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			lista = append(lista, v)
+		}
+	}
+	return lista
+}
@@ -1,64 +1,124 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"flag"
 	"fmt"
 	"log"
+	"log/slog"
+	"os"
 	"sync"
+	"text/tabwriter"
 	"time"
 
-	"reniec/codigo"
-
-	_ "github.com/lib/pq"
+	"personas/core"
 )
 
+// NumWorkers es el valor por defecto de -workers
 const NumWorkers = 15
 
+// Modos de procesamiento
+const (
+	ModoWeb    = "web"    // consulta eldni.com por cada DNI
+	ModoLocal  = "local"  // calcula el dígito con el algoritmo de RENIEC
+	ModoVerify = "verify" // audita los códigos ya guardados
+)
+
 type Resultado struct {
-	DNI    string
-	Codigo string
-	Error  error
+	WorkerID int
+	DNI      string
+	Codigo   string
+	// Extraccion es la estrategia con que salió el código; las frágiles
+	// dejan el código en revisión en vez de escribirlo
+	Extraccion map[core.Campo]string
+	Intentos   int
+	Duracion   time.Duration
+	Error      error
 }
 
 func main() {
-	dbConfig := codigo.DBConfig{
-		Host:     "localhost",
-		Port:     5433,
-		User:     "postgres",
-		Password: "admin123",
-		DBName:   "personas",
+	base := core.ConfigDefecto()
+	base.Workers = NumWorkers
+
+	cfg, args, err := core.CargarConfig("reniec", os.Args[1:], base)
+	if err != nil {
+		log.Fatalf("Error en la configuración: %v", err)
+	}
+	if err := core.ConfigurarLogs(cfg.Log); err != nil {
+		log.Fatalf("Error en la configuración: %v", err)
 	}
 
-	db, err := codigo.ConectarDB(dbConfig)
+	drenar, abortar, stop := core.ContextoApagado(context.Background())
+	defer stop()
+
+	if cfg.Metricas != "" {
+		core.ServirMetricas(abortar, cfg.Metricas)
+	}
+
+	db, err := core.ConectarDB(cfg.DB)
 	if err != nil {
 		log.Fatalf("Error conectando a la base de datos: %v", err)
 	}
 	defer db.Close()
 
-	fmt.Printf("🚀 Iniciando procesamiento con %d workers...\n", NumWorkers)
+	if err := core.ComprobarEsquema(abortar, db); err != nil {
+		log.Fatalf("Error en el esquema de la base de datos: %v", err)
+	}
+
+	modo := ModoWeb
+	if len(args) > 0 {
+		modo = args[0]
+		args = args[1:]
+	}
+
 	startTime := time.Now()
 
-	err = procesarDNIs(db)
+	if modo == ModoVerify {
+		err = verificarCodigos(abortar, db, args)
+	} else {
+		err = procesarDNIs(drenar, abortar, db, cfg, modo)
+	}
 	if err != nil {
 		log.Fatalf("Error procesando DNIs: %v", err)
 	}
 
+	if drenar.Err() != nil {
+		fmt.Printf("⚠️  Proceso interrumpido tras %v\n", time.Since(startTime))
+		return
+	}
 	fmt.Printf("🎉 Proceso completado en %v\n", time.Since(startTime))
 }
 
-func procesarDNIs(db *sql.DB) error {
-	dnis, err := codigo.ObtenerDNIsPendientes(db)
+// procesarDNIs deja de repartir DNIs cuando se cancela drenar; las consultas
+// en curso y la escritura de sus resultados usan abortar
+func procesarDNIs(drenar, abortar context.Context, db *sql.DB, cfg core.Config, modo string) error {
+	if modo != ModoWeb && modo != ModoLocal {
+		return fmt.Errorf("modo desconocido %q (usar %s o %s)", modo, ModoWeb, ModoLocal)
+	}
+
+	// El cálculo local no depende de lo que haya respondido eldni.com
+	fuenteWeb := core.NombreEldniDigito
+	if modo == ModoLocal {
+		fuenteWeb = ""
+	}
+	dnis, err := core.ObtenerDNIsPendientes(abortar, db, fuenteWeb)
 	if err != nil {
 		return err
 	}
 
 	total := len(dnis)
 	if total == 0 {
-		fmt.Println("No hay DNIs pendientes")
+		slog.Info("no hay DNIs pendientes")
 		return nil
 	}
 
-	fmt.Printf("📋 Procesando %d DNIs\n", total)
+	if modo == ModoLocal {
+		return procesarDNIsLocal(abortar, db, dnis)
+	}
+
+	slog.Info("iniciando procesamiento", "workers", cfg.Workers, "total", total)
+	progreso := core.NuevoProgreso(total, cfg.Workers, core.RitmoLimite(cfg.Limites, core.NombreEldniDigito))
 
 	dniChan := make(chan string, total)
 	resultadoChan := make(chan Resultado, total)
@@ -73,67 +133,154 @@ func procesarDNIs(db *sql.DB) error {
 	}
 	close(dniChan)
 
-	// Iniciar workers
-	for i := 0; i < NumWorkers; i++ {
+	// Iniciar workers; la fuente no guarda estado entre DNIs y se comparte,
+	// junto con el limitador de eldni.com
+	limites := core.NuevosLimitadores(cfg.Limites)
+	fuente := core.NuevaFuenteEldniDigito(cfg.OpcionesRed(limites.Para(core.NombreEldniDigito, cfg.URLs.EldniDigito))...)
+	for i := 0; i < cfg.Workers; i++ {
 		wg.Add(1)
-		go worker(dniChan, resultadoChan, &wg)
+		go worker(drenar, progreso.ConWorker(abortar, i+1), i+1, fuente, cfg.Reintentos, cfg.Pausa, progreso, dniChan, resultadoChan, &wg)
 	}
 
-	// Procesar resultados
+	// Procesar resultados; se guardan por lotes y cada uno se cuenta cuando
+	// su lote queda escrito
+	escritor := core.NuevoEscritorLotes(abortar, db, cfg.Lotes, cfg.Reconsulta)
+	var processingWg sync.WaitGroup
+	processingWg.Add(1)
 	go func() {
+		defer processingWg.Done()
 		for resultado := range resultadoChan {
-			mu.Lock()
-			if resultado.Error != nil {
-				errores++
-				fmt.Printf("❌ Error DNI %s: %v\n", resultado.DNI, resultado.Error)
-			} else {
-				err := codigo.ActualizarCodigoVerificacion(db, resultado.DNI, resultado.Codigo)
-				if err != nil {
-					errores++
-					fmt.Printf("❌ Error BD DNI %s: %v\n", resultado.DNI, err)
-				} else {
-					exitosos++
-					fmt.Printf("✅ DNI %s: %s\n", resultado.DNI, resultado.Codigo)
-				}
-			}
-			mu.Unlock()
+			escritor.Agregar(core.Escritura{
+				Persona: core.Persona{DNI: resultado.DNI, CodigoVerif: resultado.Codigo, Extraccion: resultado.Extraccion},
+				Fuente:  core.NombreEldniDigito,
+				Error:   resultado.Error,
+				Listo: func(_ bool, errBD error) {
+					mu.Lock()
+					defer mu.Unlock()
+					attrs := append([]any{"worker_id", resultado.WorkerID},
+						core.AtributosConsulta(resultado.DNI, core.NombreEldniDigito, resultado.Intentos, resultado.Duracion, resultado.Error)...)
+					err := resultado.Error
+					switch {
+					case err != nil:
+						errores++
+						slog.Warn("consulta fallida", append(attrs, "error", err)...)
+						if errBD != nil {
+							slog.Error("error registrando resultado", append(attrs, "error", errBD)...)
+						}
+					case errBD != nil:
+						err = errBD
+						errores++
+						slog.Error("error guardando código", append(attrs, "error", err)...)
+					default:
+						exitosos++
+						slog.Info("código obtenido", attrs...)
+					}
+					progreso.Avanzar(err)
+				},
+			})
 		}
 	}()
 
 	wg.Wait()
 	close(resultadoChan)
 
-	// Esperar resultados
-	time.Sleep(2 * time.Second)
+	// Esperar a que se guarden todos los resultados
+	processingWg.Wait()
+	escritor.Cerrar()
+	progreso.Terminar()
 
-	fmt.Printf("\n📊 Resultados: %d exitosos, %d errores de %d total\n", exitosos, errores, total)
+	fmt.Printf("\n📊 Resultados: %d exitosos, %d errores, %d sin procesar de %d total\n",
+		exitosos, errores, total-exitosos-errores, total)
 	return nil
 }
 
-func worker(dniChan <-chan string, resultadoChan chan<- Resultado, wg *sync.WaitGroup) {
-	defer wg.Done()
+// procesarDNIsLocal calcula todos los códigos sin salir a la red y los
+// guarda en una sola transacción
+func procesarDNIsLocal(ctx context.Context, db *sql.DB, dnis []string) error {
+	slog.Info("calculando códigos verificadores localmente", "total", len(dnis))
+	progreso := core.NuevoProgreso(len(dnis), 0, 0)
 
-	for dni := range dniChan {
-		codigo, err := procesarDNI(dni)
-		resultadoChan <- Resultado{DNI: dni, Codigo: codigo, Error: err}
-		time.Sleep(1 * time.Second)
+	codigos := make(map[string]string, len(dnis))
+	errores := 0
+	for _, dni := range dnis {
+		numero, _, err := core.CalcularDigitoVerificador(dni)
+		progreso.Avanzar(err)
+		if err != nil {
+			errores++
+			slog.Warn("DNI inválido", core.AtributoDNI(dni), "error", err)
+			continue
+		}
+		codigos[dni] = numero
+	}
+	progreso.Terminar()
+
+	if err := core.ActualizarCodigosVerificacion(ctx, db, codigos, core.NombreDigitoLocal); err != nil {
+		return err
 	}
+
+	fmt.Printf("\n📊 Resultados: %d exitosos, %d errores de %d total\n", len(codigos), errores, len(dnis))
+	return nil
 }
 
-func procesarDNI(dni string) (string, error) {
-	maxReintentos := 2
-	var lastError error
+// verificarCodigos recalcula cada código guardado, lista las discrepancias y,
+// con -corregir, las reemplaza por el valor calculado
+func verificarCodigos(ctx context.Context, db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet(ModoVerify, flag.ContinueOnError)
+	corregir := fs.Bool("corregir", false, "escribir los códigos corregidos en la BD")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
 
-	for intento := 1; intento <= maxReintentos; intento++ {
-		codigo, err := codigo.ObtenerCodigoVerificacion(dni)
-		if err == nil {
-			return codigo, nil
+	registros, err := core.ObtenerCodigosRegistrados(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("verificando códigos registrados", "total", len(registros))
+	discrepancias, invalidos := core.VerificarCodigos(registros)
+
+	for _, dni := range invalidos {
+		fmt.Printf("⚠️  DNI %s con formato inválido, no se puede verificar\n", dni)
+	}
+
+	if len(discrepancias) > 0 {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "DNI\tREGISTRADO\tESPERADO")
+		for _, d := range discrepancias {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", d.DNI, d.Registrado, d.Esperado)
 		}
-		lastError = err
-		if intento < maxReintentos {
-			time.Sleep(time.Duration(intento*2) * time.Second)
+		tw.Flush()
+	}
+
+	fmt.Printf("\n📊 Resultados: %d correctos, %d discrepancias, %d inválidos de %d total\n",
+		len(registros)-len(discrepancias)-len(invalidos), len(discrepancias), len(invalidos), len(registros))
+
+	if *corregir && len(discrepancias) > 0 {
+		if err := core.CorregirCodigos(ctx, db, discrepancias); err != nil {
+			return fmt.Errorf("error corrigiendo códigos: %v", err)
 		}
+		fmt.Printf("✅ %d códigos corregidos\n", len(discrepancias))
 	}
 
-	return "", lastError
+	return nil
+}
+
+// abortar lleva el worker de progreso para que las consultas informen su estado
+func worker(drenar, abortar context.Context, workerID int, fuente core.Source, politica core.PoliticaReintento, pausa time.Duration, progreso *core.Progreso, dniChan <-chan string, resultadoChan chan<- Resultado, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer progreso.WorkerTerminado(workerID)
+
+	for dni := range dniChan {
+		if drenar.Err() != nil {
+			return
+		}
+		inicio := time.Now()
+		datos, intentos, err := core.LookupConReintentos(abortar, fuente, dni, politica)
+		resultado := Resultado{WorkerID: workerID, DNI: dni, Intentos: intentos, Duracion: time.Since(inicio), Error: err}
+		if err == nil {
+			resultado.Codigo, resultado.Extraccion = datos.CodigoVerif, datos.Extraccion
+		}
+		resultadoChan <- resultado
+		core.Dormir(drenar, pausa)
+	}
 }